@@ -33,6 +33,7 @@ func runApp(cmd *cobra.Command, args []string) {
 	mainLogger := log.Named("Main")
 	mainLogger.Info("Starting server")
 	config.Load(log, cmd)
+	utils.InitLinkSigning(log)
 	router := getRouter(log)
 
 	mainBot, err := bot.StartClient(log)
@@ -47,7 +48,14 @@ func runApp(cmd *cobra.Command, args []string) {
 	}
 	
 	cache.InitCache(log)
+	cache.InitChunkCache(log)
 	cache.InitStatsCache(log)
+	cache.InitRevocations(log)
+	cache.InitLinks(log)
+	cache.InitPasswords(log)
+	cache.InitBindings(log)
+	cache.InitSlugs(log)
+	cache.InitFiles(log)
 	workers, err := bot.StartWorkers(log)
 	if err != nil {
 		log.Panic("Failed to start workers", zap.Error(err))
@@ -71,6 +79,11 @@ func getRouter(log *zap.Logger) *gin.Engine {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	router := gin.Default()
+	// client IPs are taken from X-Forwarded-For only when it was set by one
+	// of the trusted proxies
+	if err := router.SetTrustedProxies(config.ValueOf.TrustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES", zap.Error(err))
+	}
 	router.Use(gin.ErrorLogger())
 	router.GET("/", func(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, types.RootResponse{
@@ -5,12 +5,16 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
@@ -38,20 +42,46 @@ func (au *allowedUsers) Decode(value string) error {
 }
 
 type config struct {
-	APIID            int64    `envconfig:"API_ID" required:"true"`
-	APIHash          string   `envconfig:"API_HASH" required:"true"`
-	BotToken         string   `envconfig:"BOT_TOKEN" required:"true"`
-	LogChannelID     int64    `envconfig:"LOG_CHANNEL" required:"true"`
-	Host             string   `envconfig:"HOST" required:"true"`
-	Port             int      `envconfig:"PORT" required:"true"`
-	AllowedUsers     []int64  `envconfig:"ALLOWED_USERS"`
-	ForceSubChannel  string   `envconfig:"FORCE_SUB_CHANNEL"`
-	Dev              bool     `envconfig:"DEV" default:"false"`
-	HashLength       int      `envconfig:"HASH_LENGTH" default:"6"`
-	UseSessionFile   bool     `envconfig:"USE_SESSION_FILE" default:"true"`
-	UserSession      string   `envconfig:"USER_SESSION"`
-	UsePublicIP      bool     `envconfig:"USE_PUBLIC_IP" default:"false"`
-	MultiTokens      []string
+	APIID                   int64         `envconfig:"API_ID" required:"true"`
+	APIHash                 string        `envconfig:"API_HASH" required:"true"`
+	BotToken                string        `envconfig:"BOT_TOKEN" required:"true"`
+	LogChannelID            int64         `envconfig:"LOG_CHANNEL" required:"true"`
+	Host                    string        `envconfig:"HOST" required:"true"`
+	Port                    int           `envconfig:"PORT" required:"true"`
+	AllowedUsers            []int64       `envconfig:"ALLOWED_USERS"`
+	ForceSubChannel         string        `envconfig:"FORCE_SUB_CHANNEL"`
+	Dev                     bool          `envconfig:"DEV" default:"false"`
+	HashLength              int           `envconfig:"HASH_LENGTH" default:"6"`
+	UseSessionFile          bool          `envconfig:"USE_SESSION_FILE" default:"true"`
+	UserSession             string        `envconfig:"USER_SESSION"`
+	UsePublicIP             bool          `envconfig:"USE_PUBLIC_IP" default:"false"`
+	StreamConcurrency       int           `envconfig:"STREAM_CONCURRENCY" default:"4"`
+	ChunkCacheSize          int64         `envconfig:"CHUNK_CACHE_SIZE" default:"0"` // in MiB, 0 disables the cache
+	ChunkCacheDir           string        `envconfig:"CHUNK_CACHE_DIR" default:"data/chunks"`
+	LinkKinds               []string      `envconfig:"LINK_KINDS" default:"stream,download,watch,proxy"`
+	StreamLinkTemplate      string        `envconfig:"STREAM_LINK_TEMPLATE" default:"{host}/stream/{id}?hash={hash}"`
+	DownloadLinkTemplate    string        `envconfig:"DOWNLOAD_LINK_TEMPLATE" default:"{host}/stream/{id}?hash={hash}&d=true"`
+	WatchLinkTemplate       string        `envconfig:"WATCH_LINK_TEMPLATE" default:"{host}/watch/{id}?hash={hash}"`
+	ProxyLinkTemplate       string        `envconfig:"PROXY_LINK_TEMPLATE"`
+	LinkSecret              string        `envconfig:"LINK_SECRET"`
+	PreviousLinkSecret      string        `envconfig:"PREVIOUS_LINK_SECRET"`
+	PreviousLinkSecretUntil time.Time     `envconfig:"PREVIOUS_LINK_SECRET_UNTIL"`
+	LinkExpiry              time.Duration `envconfig:"LINK_EXPIRY" default:"0"`
+	AllowLegacyHash         bool          `envconfig:"ALLOW_LEGACY_HASH" default:"true"`
+	AdminUsers              []int64       `envconfig:"ADMIN_USERS"`
+	APIKey                  string        `envconfig:"API_KEY"`
+	AllowedReferers         []string      `envconfig:"ALLOWED_REFERERS"`
+	EmptyRefererKinds       []string      `envconfig:"EMPTY_REFERER_KINDS" default:"stream,download,watch"`
+	LinkIPBinding           string        `envconfig:"LINK_IP_BINDING" default:"off"`
+	TrustedProxies          []string      `envconfig:"TRUSTED_PROXIES"`
+	GlobalRateLimit         int64         `envconfig:"GLOBAL_RATE_LIMIT" default:"0"` // in KiB/s, 0 disables the limit
+	IPRateLimit             int64         `envconfig:"IP_RATE_LIMIT" default:"0"`     // in KiB/s, 0 disables the limit
+	LinkRateLimit           int64         `envconfig:"LINK_RATE_LIMIT" default:"0"`   // in KiB/s, 0 disables the limit
+	MaxStreamsPerIP         int           `envconfig:"MAX_STREAMS_PER_IP" default:"0"`
+	RecordDuplicateUploads  bool          `envconfig:"RECORD_DUPLICATE_UPLOADS" default:"true"`
+	UploadMode              string        `envconfig:"UPLOAD_MODE" default:"forward"`
+	UploaderCaption         bool          `envconfig:"UPLOADER_CAPTION" default:"false"`
+	MultiTokens             []string
 }
 
 var botTokenRegex = regexp.MustCompile(`MULTI\_TOKEN\d+=(.*)`)
@@ -85,6 +115,32 @@ func (c *config) SetFlagsFromConfig(cmd *cobra.Command) {
 	cmd.Flags().Bool("use-session-file", c.UseSessionFile, "Use session files")
 	cmd.Flags().String("user-session", c.UserSession, "Pyrogram user session")
 	cmd.Flags().Bool("use-public-ip", c.UsePublicIP, "Use public IP instead of local IP")
+	cmd.Flags().Int("stream-concurrency", c.StreamConcurrency, "Number of chunks fetched in parallel for each stream")
+	cmd.Flags().Int64("chunk-cache-size", c.ChunkCacheSize, "Size limit of the on-disk chunk cache in MiB (0 disables it)")
+	cmd.Flags().String("chunk-cache-dir", c.ChunkCacheDir, "Directory of the on-disk chunk cache")
+	cmd.Flags().StringSlice("link-kinds", c.LinkKinds, "Kinds of links sent for every file (stream, download, watch, proxy)")
+	cmd.Flags().String("stream-link-template", c.StreamLinkTemplate, "Template of stream links")
+	cmd.Flags().String("download-link-template", c.DownloadLinkTemplate, "Template of download links")
+	cmd.Flags().String("watch-link-template", c.WatchLinkTemplate, "Template of watch page links")
+	cmd.Flags().String("proxy-link-template", c.ProxyLinkTemplate, "Template of external proxy links")
+	cmd.Flags().String("link-secret", c.LinkSecret, "Secret used to sign links (generated and stored in data/ when empty)")
+	cmd.Flags().String("previous-link-secret", c.PreviousLinkSecret, "Previous link secret, still accepted while rotating secrets")
+	cmd.Flags().String("previous-link-secret-until", "", "RFC 3339 time until which the previous link secret is accepted")
+	cmd.Flags().Duration("link-expiry", c.LinkExpiry, "How long new links stay valid (0 never expires)")
+	cmd.Flags().Bool("allow-legacy-hash", true, "Accept unsigned legacy link hashes")
+	cmd.Flags().Int64Slice("admin-users", c.AdminUsers, "User IDs allowed to use admin commands")
+	cmd.Flags().String("api-key", c.APIKey, "Key of the admin API (disabled when empty)")
+	cmd.Flags().StringSlice("allowed-referers", c.AllowedReferers, "Sites allowed to embed links, eg. example.com or *.example.com (any when empty)")
+	cmd.Flags().StringSlice("empty-referer-kinds", c.EmptyRefererKinds, "Link kinds that may be opened without a Referer or Origin")
+	cmd.Flags().String("link-ip-binding", c.LinkIPBinding, "Bind signed links to the client on first use (off, ip, subnet)")
+	cmd.Flags().StringSlice("trusted-proxies", c.TrustedProxies, "IPs or CIDRs of reverse proxies whose X-Forwarded-For is trusted (none when empty)")
+	cmd.Flags().Int64("global-rate-limit", c.GlobalRateLimit, "Bandwidth limit of all streams together in KiB/s (0 disables it)")
+	cmd.Flags().Int64("ip-rate-limit", c.IPRateLimit, "Bandwidth limit of the streams of one IP in KiB/s (0 disables it)")
+	cmd.Flags().Int64("link-rate-limit", c.LinkRateLimit, "Bandwidth limit of the streams of one link in KiB/s (0 disables it)")
+	cmd.Flags().Int("max-streams-per-ip", c.MaxStreamsPerIP, "Concurrent streams allowed for one IP (0 allows any)")
+	cmd.Flags().Bool("record-duplicate-uploads", true, "Add files sent again to the history of the user who sent them")
+	cmd.Flags().String("upload-mode", c.UploadMode, "How files get to the log channel (forward, copy)")
+	cmd.Flags().Bool("uploader-caption", false, "Caption copies in the log channel with the ID of the uploader")
 	cmd.Flags().String("multi-token-txt-file", "", "Multi token txt file (Not implemented)")
 }
 
@@ -130,6 +186,114 @@ func (c *config) loadConfigFromArgs(log *zap.Logger, cmd *cobra.Command) {
 	if usePublicIP {
 		os.Setenv("USE_PUBLIC_IP", strconv.FormatBool(usePublicIP))
 	}
+	streamConcurrency, _ := cmd.Flags().GetInt("stream-concurrency")
+	if streamConcurrency != 0 {
+		os.Setenv("STREAM_CONCURRENCY", strconv.Itoa(streamConcurrency))
+	}
+	chunkCacheSize, _ := cmd.Flags().GetInt64("chunk-cache-size")
+	if chunkCacheSize != 0 {
+		os.Setenv("CHUNK_CACHE_SIZE", strconv.FormatInt(chunkCacheSize, 10))
+	}
+	chunkCacheDir, _ := cmd.Flags().GetString("chunk-cache-dir")
+	if chunkCacheDir != "" {
+		os.Setenv("CHUNK_CACHE_DIR", chunkCacheDir)
+	}
+	linkKinds, _ := cmd.Flags().GetStringSlice("link-kinds")
+	if len(linkKinds) != 0 {
+		os.Setenv("LINK_KINDS", strings.Join(linkKinds, ","))
+	}
+	streamLinkTemplate, _ := cmd.Flags().GetString("stream-link-template")
+	if streamLinkTemplate != "" {
+		os.Setenv("STREAM_LINK_TEMPLATE", streamLinkTemplate)
+	}
+	downloadLinkTemplate, _ := cmd.Flags().GetString("download-link-template")
+	if downloadLinkTemplate != "" {
+		os.Setenv("DOWNLOAD_LINK_TEMPLATE", downloadLinkTemplate)
+	}
+	watchLinkTemplate, _ := cmd.Flags().GetString("watch-link-template")
+	if watchLinkTemplate != "" {
+		os.Setenv("WATCH_LINK_TEMPLATE", watchLinkTemplate)
+	}
+	proxyLinkTemplate, _ := cmd.Flags().GetString("proxy-link-template")
+	if proxyLinkTemplate != "" {
+		os.Setenv("PROXY_LINK_TEMPLATE", proxyLinkTemplate)
+	}
+	linkSecret, _ := cmd.Flags().GetString("link-secret")
+	if linkSecret != "" {
+		os.Setenv("LINK_SECRET", linkSecret)
+	}
+	previousLinkSecret, _ := cmd.Flags().GetString("previous-link-secret")
+	if previousLinkSecret != "" {
+		os.Setenv("PREVIOUS_LINK_SECRET", previousLinkSecret)
+	}
+	previousLinkSecretUntil, _ := cmd.Flags().GetString("previous-link-secret-until")
+	if previousLinkSecretUntil != "" {
+		os.Setenv("PREVIOUS_LINK_SECRET_UNTIL", previousLinkSecretUntil)
+	}
+	linkExpiry, _ := cmd.Flags().GetDuration("link-expiry")
+	if linkExpiry != 0 {
+		os.Setenv("LINK_EXPIRY", linkExpiry.String())
+	}
+	if cmd.Flags().Changed("allow-legacy-hash") {
+		allowLegacyHash, _ := cmd.Flags().GetBool("allow-legacy-hash")
+		os.Setenv("ALLOW_LEGACY_HASH", strconv.FormatBool(allowLegacyHash))
+	}
+	adminUsers, _ := cmd.Flags().GetInt64Slice("admin-users")
+	if len(adminUsers) != 0 {
+		ids := make([]string, len(adminUsers))
+		for i, id := range adminUsers {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		os.Setenv("ADMIN_USERS", strings.Join(ids, ","))
+	}
+	apiKey, _ := cmd.Flags().GetString("api-key")
+	if apiKey != "" {
+		os.Setenv("API_KEY", apiKey)
+	}
+	allowedReferers, _ := cmd.Flags().GetStringSlice("allowed-referers")
+	if len(allowedReferers) != 0 {
+		os.Setenv("ALLOWED_REFERERS", strings.Join(allowedReferers, ","))
+	}
+	emptyRefererKinds, _ := cmd.Flags().GetStringSlice("empty-referer-kinds")
+	if len(emptyRefererKinds) != 0 {
+		os.Setenv("EMPTY_REFERER_KINDS", strings.Join(emptyRefererKinds, ","))
+	}
+	linkIPBinding, _ := cmd.Flags().GetString("link-ip-binding")
+	if linkIPBinding != "" {
+		os.Setenv("LINK_IP_BINDING", linkIPBinding)
+	}
+	trustedProxies, _ := cmd.Flags().GetStringSlice("trusted-proxies")
+	if len(trustedProxies) != 0 {
+		os.Setenv("TRUSTED_PROXIES", strings.Join(trustedProxies, ","))
+	}
+	globalRateLimit, _ := cmd.Flags().GetInt64("global-rate-limit")
+	if globalRateLimit != 0 {
+		os.Setenv("GLOBAL_RATE_LIMIT", strconv.FormatInt(globalRateLimit, 10))
+	}
+	ipRateLimit, _ := cmd.Flags().GetInt64("ip-rate-limit")
+	if ipRateLimit != 0 {
+		os.Setenv("IP_RATE_LIMIT", strconv.FormatInt(ipRateLimit, 10))
+	}
+	linkRateLimit, _ := cmd.Flags().GetInt64("link-rate-limit")
+	if linkRateLimit != 0 {
+		os.Setenv("LINK_RATE_LIMIT", strconv.FormatInt(linkRateLimit, 10))
+	}
+	maxStreamsPerIP, _ := cmd.Flags().GetInt("max-streams-per-ip")
+	if maxStreamsPerIP != 0 {
+		os.Setenv("MAX_STREAMS_PER_IP", strconv.Itoa(maxStreamsPerIP))
+	}
+	if cmd.Flags().Changed("record-duplicate-uploads") {
+		recordDuplicateUploads, _ := cmd.Flags().GetBool("record-duplicate-uploads")
+		os.Setenv("RECORD_DUPLICATE_UPLOADS", strconv.FormatBool(recordDuplicateUploads))
+	}
+	uploadMode, _ := cmd.Flags().GetString("upload-mode")
+	if uploadMode != "" {
+		os.Setenv("UPLOAD_MODE", uploadMode)
+	}
+	if cmd.Flags().Changed("uploader-caption") {
+		uploaderCaption, _ := cmd.Flags().GetBool("uploader-caption")
+		os.Setenv("UPLOADER_CAPTION", strconv.FormatBool(uploaderCaption))
+	}
 	multiTokens, _ := cmd.Flags().GetString("multi-token-txt-file")
 	if multiTokens != "" {
 		os.Setenv("MULTI_TOKEN_TXT_FILE", multiTokens)
@@ -188,8 +352,107 @@ func Load(log *zap.Logger, cmd *cobra.Command) {
 		log.Sugar().Info("HASH_LENGTH can't be less than 5, defaulting to 6")
 		ValueOf.HashLength = 6
 	}
+	if ValueOf.StreamConcurrency < 1 {
+		log.Sugar().Info("STREAM_CONCURRENCY can't be less than 1, changing to 1")
+		ValueOf.StreamConcurrency = 1
+	}
+	if ValueOf.StreamConcurrency > 16 {
+		log.Sugar().Info("STREAM_CONCURRENCY can't be more than 16, changing to 16")
+		ValueOf.StreamConcurrency = 16
+	}
+	if ValueOf.GlobalRateLimit < 0 || ValueOf.IPRateLimit < 0 || ValueOf.LinkRateLimit < 0 {
+		log.Sugar().Info("Rate limits can't be negative, disabling the negative ones")
+		ValueOf.GlobalRateLimit = max(ValueOf.GlobalRateLimit, 0)
+		ValueOf.IPRateLimit = max(ValueOf.IPRateLimit, 0)
+		ValueOf.LinkRateLimit = max(ValueOf.LinkRateLimit, 0)
+	}
+	if ValueOf.MaxStreamsPerIP < 0 {
+		log.Sugar().Info("MAX_STREAMS_PER_IP can't be negative, allowing any number of streams")
+		ValueOf.MaxStreamsPerIP = 0
+	}
+	if ValueOf.LinkExpiry < 0 {
+		log.Sugar().Info("LINK_EXPIRY can't be negative, links won't expire")
+		ValueOf.LinkExpiry = 0
+	}
+	ValueOf.Host = strings.TrimSuffix(ValueOf.Host, "/")
+	var linkKinds []string
+	for _, kind := range ValueOf.LinkKinds {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if !slices.Contains(linkKindNames, kind) {
+			log.Sugar().Warnf("Unknown link kind %q in LINK_KINDS, ignoring it", kind)
+			continue
+		}
+		linkKinds = append(linkKinds, kind)
+	}
+	if len(linkKinds) == 0 {
+		log.Sugar().Info("LINK_KINDS has no valid kinds, defaulting to stream")
+		linkKinds = []string{"stream"}
+	}
+	ValueOf.LinkKinds = linkKinds
+	var allowedReferers []string
+	for _, referer := range ValueOf.AllowedReferers {
+		referer = strings.ToLower(strings.TrimSpace(referer))
+		// accept origins like https://example.com as well as bare hosts
+		if u, err := url.Parse(referer); err == nil && u.Host != "" {
+			referer = u.Hostname()
+		}
+		if referer != "" {
+			allowedReferers = append(allowedReferers, referer)
+		}
+	}
+	ValueOf.AllowedReferers = allowedReferers
+	var emptyRefererKinds []string
+	for _, kind := range ValueOf.EmptyRefererKinds {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if kind == "" {
+			continue
+		}
+		if !slices.Contains(linkKindNames, kind) {
+			log.Sugar().Warnf("Unknown link kind %q in EMPTY_REFERER_KINDS, ignoring it", kind)
+			continue
+		}
+		emptyRefererKinds = append(emptyRefererKinds, kind)
+	}
+	ValueOf.EmptyRefererKinds = emptyRefererKinds
+	ValueOf.LinkIPBinding = strings.ToLower(strings.TrimSpace(ValueOf.LinkIPBinding))
+	if !slices.Contains(linkIPBindings, ValueOf.LinkIPBinding) {
+		log.Sugar().Warnf("Unknown LINK_IP_BINDING %q, links won't be bound", ValueOf.LinkIPBinding)
+		ValueOf.LinkIPBinding = "off"
+	}
+	var trustedProxies []string
+	for _, proxy := range ValueOf.TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if _, err := netip.ParsePrefix(proxy); err != nil {
+			if _, err := netip.ParseAddr(proxy); err != nil {
+				log.Sugar().Warnf("Invalid address %q in TRUSTED_PROXIES, ignoring it", proxy)
+				continue
+			}
+		}
+		trustedProxies = append(trustedProxies, proxy)
+	}
+	ValueOf.TrustedProxies = trustedProxies
+	ValueOf.UploadMode = strings.ToLower(strings.TrimSpace(ValueOf.UploadMode))
+	if !slices.Contains(uploadModes, ValueOf.UploadMode) {
+		log.Sugar().Warnf("Unknown UPLOAD_MODE %q, files will be forwarded", ValueOf.UploadMode)
+		ValueOf.UploadMode = "forward"
+	}
+	if ValueOf.UploaderCaption && ValueOf.UploadMode != "copy" {
+		log.Warn("UPLOADER_CAPTION only works with UPLOAD_MODE=copy, forwarded files keep their own caption")
+	}
 }
 
+// linkKindNames are the kinds of links that can be listed in LINK_KINDS.
+var linkKindNames = []string{"stream", "download", "watch", "proxy"}
+
+// linkIPBindings are the values of LINK_IP_BINDING.
+var linkIPBindings = []string{"off", "ip", "subnet"}
+
+// uploadModes are the values of UPLOAD_MODE.
+var uploadModes = []string{"forward", "copy"}
+
 func getIP(public bool) (string, error) {
 	var ip string
 	var err error
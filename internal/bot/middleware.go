@@ -1,11 +1,15 @@
 package bot
 
 import (
+	"strings"
 	"time"
 
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/ext"
 	"github.com/gotd/contrib/middleware/floodwait"
 	"github.com/gotd/contrib/middleware/ratelimit"
 	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
 	"go.uber.org/zap"
 	"golang.org/x/time/rate"
 )
@@ -18,3 +22,29 @@ func GetFloodMiddleware(log *zap.Logger) []telegram.Middleware {
 		ratelimiter,
 	}
 }
+
+// GetPanicHandler logs handlers that panic and tells the user something went
+// wrong, so one bad update never stops the bot from handling the next ones.
+func GetPanicHandler(log *zap.Logger) dispatcher.PanicHandler {
+	log = log.Named("Dispatcher")
+	return func(ctx *ext.Context, u *ext.Update, stack string) {
+		message, stack, _ := strings.Cut(stack, "\n")
+		log.Error("Handler panicked", zap.String("panic", message), zap.String("stack", stack))
+		defer func() {
+			// a panic in here would take the whole bot down
+			if r := recover(); r != nil {
+				log.Error("Failed to report panic", zap.Any("panic", r))
+			}
+		}()
+		switch {
+		case u.CallbackQuery != nil:
+			ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+				QueryID: u.CallbackQuery.QueryID,
+				Message: "Something went wrong, please try again later.",
+				Alert:   true,
+			})
+		case u.EffectiveMessage != nil && u.EffectiveMessage.Message != nil && !u.EffectiveMessage.Out:
+			ctx.Reply(u, "Something went wrong, please try again later.", nil)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package bot
 
 import (
 	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/utils"
 	"context"
 	"fmt"
 	"os"
@@ -92,6 +93,23 @@ func GetNextWorker() *Worker {
 	return worker
 }
 
+// GetNextWorkerExcluding works like GetNextWorker but skips the workers whose
+// user IDs are in exclude. It returns nil when every worker has been excluded.
+func GetNextWorkerExcluding(exclude []int64) *Worker {
+	Workers.mut.Lock()
+	defer Workers.mut.Unlock()
+	for range Workers.Bots {
+		index := (Workers.index + 1) % len(Workers.Bots)
+		Workers.index = index
+		worker := Workers.Bots[index]
+		if !utils.Contains(exclude, worker.Self.ID) {
+			Workers.log.Sugar().Debugf("Using worker %d", worker.ID)
+			return worker
+		}
+	}
+	return nil
+}
+
 func StartWorkers(log *zap.Logger) (*BotWorkers, error) {
 	Workers.Init(log)
 
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/types"
+	"container/list"
+	"errors"
+	"net/netip"
+	"sync"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxCachedBindings caps how many bindings are kept in memory. The least
+// recently used ones are loaded from the database again when needed.
+const maxCachedBindings = 10000
+
+// BindingStore remembers which network each bound link was first used from.
+// Bindings are loaded from the database on first use and the recently used
+// ones kept in memory.
+type BindingStore struct {
+	db      *gorm.DB
+	log     *zap.Logger
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type bindingEntry struct {
+	token   string
+	network netip.Prefix
+}
+
+var bindingStore *BindingStore
+
+func InitBindings(log *zap.Logger) {
+	log = log.Named("bindings")
+	defer log.Sugar().Info("Initialized binding store")
+
+	db := database.GetDB()
+	if db == nil {
+		log.Error("Database not initialized")
+		return
+	}
+
+	bindingStore = &BindingStore{
+		db:      db,
+		log:     log,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// GetBindings returns the binding store, or nil when the database is not
+// available.
+func GetBindings() *BindingStore {
+	return bindingStore
+}
+
+// Bind binds a link to network when it is used for the first time, and
+// reports whether addr may use the link.
+func (bs *BindingStore) Bind(token string, messageID int, network netip.Prefix, addr netip.Addr) (bool, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if element, ok := bs.entries[token]; ok {
+		bs.lru.MoveToFront(element)
+		return element.Value.(*bindingEntry).network.Contains(addr), nil
+	}
+
+	var binding types.LinkBinding
+	err := bs.db.First(&binding, "token = ?", token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		binding = types.LinkBinding{Token: token, MessageID: messageID, Network: network.String()}
+		err = bs.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&binding).Error
+	}
+	if err != nil {
+		return false, err
+	}
+	bound, err := netip.ParsePrefix(binding.Network)
+	if err != nil {
+		return false, err
+	}
+	bs.remember(token, bound)
+	return bound.Contains(addr), nil
+}
+
+// remember keeps a binding in memory, dropping the least recently used one
+// when there are too many.
+func (bs *BindingStore) remember(token string, network netip.Prefix) {
+	bs.entries[token] = bs.lru.PushFront(&bindingEntry{token: token, network: network})
+	if bs.lru.Len() > maxCachedBindings {
+		entry := bs.lru.Remove(bs.lru.Back()).(*bindingEntry)
+		delete(bs.entries, entry.token)
+	}
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/types"
+	"container/list"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB backs the stores with an empty in-memory database holding the
+// tables of models.
+func testDB(t *testing.T, models ...interface{}) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would get a database of its own
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	old := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = old })
+}
+
+func testBindings(t *testing.T) *BindingStore {
+	t.Helper()
+	testDB(t, &types.LinkBinding{})
+	InitBindings(zap.NewNop())
+	return GetBindings()
+}
+
+func TestBind(t *testing.T) {
+	first := netip.MustParsePrefix("10.0.0.0/24")
+	tests := []struct {
+		name    string
+		addr    string
+		forget  bool // drop the binding from memory first
+		allowed bool
+	}{
+		{name: "same address", addr: "10.0.0.1", allowed: true},
+		{name: "same network", addr: "10.0.0.200", allowed: true},
+		{name: "other network", addr: "10.0.1.1"},
+		{name: "same network from the database", addr: "10.0.0.7", forget: true, allowed: true},
+		{name: "other network from the database", addr: "10.0.1.1", forget: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bindings := testBindings(t)
+			if ok, err := bindings.Bind("token", 1, first, netip.MustParseAddr("10.0.0.1")); err != nil || !ok {
+				t.Fatalf("first use = %v, %v", ok, err)
+			}
+			if tt.forget {
+				bindings.lru.Init()
+				clear(bindings.entries)
+			}
+			addr := netip.MustParseAddr(tt.addr)
+			ok, err := bindings.Bind("token", 1, netip.PrefixFrom(addr, 24).Masked(), addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.allowed {
+				t.Fatalf("Bind = %v, want %v", ok, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestBindingsBounded(t *testing.T) {
+	bindings := &BindingStore{lru: list.New(), entries: make(map[string]*list.Element)}
+	network := netip.MustParsePrefix("10.0.0.0/24")
+	for i := 0; i <= maxCachedBindings; i++ {
+		bindings.remember(fmt.Sprintf("token%d", i), network)
+	}
+	if len(bindings.entries) != maxCachedBindings || bindings.lru.Len() != maxCachedBindings {
+		t.Fatalf("kept %d bindings, want %d", len(bindings.entries), maxCachedBindings)
+	}
+	if _, ok := bindings.entries["token0"]; ok {
+		t.Fatal("the least recently used binding was kept")
+	}
+}
@@ -0,0 +1,196 @@
+package cache
+
+import (
+	"EverythingSuckz/fsb/config"
+	"container/list"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const chunkFileExt = ".chunk"
+
+// ChunkCache is a size bounded, least recently used store of file chunks on
+// disk. Keys are built by the reader from the document ID and chunk offset.
+type ChunkCache struct {
+	dir      string
+	maxBytes int64
+	size     int64
+	lru      *list.List
+	entries  map[string]*list.Element
+	mu       sync.Mutex
+	log      *zap.Logger
+}
+
+type chunkEntry struct {
+	key  string
+	size int64
+}
+
+var chunkCache *ChunkCache
+
+func InitChunkCache(log *zap.Logger) {
+	log = log.Named("chunk_cache")
+	if config.ValueOf.ChunkCacheSize <= 0 {
+		log.Sugar().Info("Disabled")
+		return
+	}
+	dir := filepath.Clean(config.ValueOf.ChunkCacheDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Error("Failed to create chunk cache directory", zap.Error(err))
+		return
+	}
+	c := &ChunkCache{
+		dir:      dir,
+		maxBytes: config.ValueOf.ChunkCacheSize * 1024 * 1024,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		log:      log,
+	}
+	c.load()
+	chunkCache = c
+	log.Sugar().Infof("Initialized with %d chunks (%d/%d bytes)", len(c.entries), c.size, c.maxBytes)
+}
+
+// GetChunkCache returns the chunk cache, or nil when it is disabled.
+func GetChunkCache() *ChunkCache {
+	return chunkCache
+}
+
+// load indexes the chunks left over from a previous run, most recently used
+// first, and trims them down to the configured size.
+func (c *ChunkCache) load() {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		c.log.Error("Failed to read chunk cache directory", zap.Error(err))
+		return
+	}
+	type found struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	chunks := make([]found, 0, len(dirEntries))
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(c.dir, name))
+			continue
+		}
+		if entry.IsDir() || !strings.HasSuffix(name, chunkFileExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		chunks = append(chunks, found{strings.TrimSuffix(name, chunkFileExt), info.Size(), info.ModTime()})
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].modTime.After(chunks[j].modTime)
+	})
+	for _, chunk := range chunks {
+		c.entries[chunk.key] = c.lru.PushBack(&chunkEntry{key: chunk.key, size: chunk.size})
+		c.size += chunk.size
+	}
+	c.evict()
+}
+
+func (c *ChunkCache) path(key string) string {
+	return filepath.Join(c.dir, key+chunkFileExt)
+}
+
+// Get returns a cached chunk and marks it as recently used.
+func (c *ChunkCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	element, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(element)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		c.log.Debug("Dropping unreadable chunk", zap.String("key", key), zap.Error(err))
+		c.remove(key)
+		return nil, false
+	}
+	now := time.Now()
+	_ = os.Chtimes(c.path(key), now, now)
+	return data, true
+}
+
+// Put stores a chunk, evicting the least recently used ones when the cache
+// grows past its size limit.
+func (c *ChunkCache) Put(key string, data []byte) {
+	size := int64(len(data))
+	if size == 0 || size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	_, exists := c.entries[key]
+	c.mu.Unlock()
+	if exists {
+		return
+	}
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		c.log.Error("Failed to create chunk file", zap.Error(err))
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		c.log.Error("Failed to write chunk file", zap.String("key", key), zap.Error(err))
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[key]; exists {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&chunkEntry{key: key, size: size})
+	c.size += size
+	c.evict()
+}
+
+func (c *ChunkCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+// evict must be called with c.mu held.
+func (c *ChunkCache) evict() {
+	for c.size > c.maxBytes {
+		element := c.lru.Back()
+		if element == nil {
+			return
+		}
+		c.removeElement(element)
+	}
+}
+
+func (c *ChunkCache) removeElement(element *list.Element) {
+	entry := c.lru.Remove(element).(*chunkEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	if err := os.Remove(c.path(entry.key)); err != nil && !os.IsNotExist(err) {
+		c.log.Debug("Failed to remove chunk file", zap.String("key", entry.key), zap.Error(err))
+	}
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"EverythingSuckz/fsb/config"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// testChunkCache opens a chunk cache of 1 MiB in dir.
+func testChunkCache(t *testing.T, dir string) *ChunkCache {
+	t.Helper()
+	old := *config.ValueOf
+	t.Cleanup(func() { *config.ValueOf = old })
+	config.ValueOf.ChunkCacheDir = dir
+	config.ValueOf.ChunkCacheSize = 1
+	InitChunkCache(zap.NewNop())
+	return GetChunkCache()
+}
+
+func TestChunkCache(t *testing.T) {
+	const part = 400 * 1024
+	chunk := func(b byte) []byte { return bytes.Repeat([]byte{b}, part) }
+
+	tests := []struct {
+		name string
+		// puts and gets, in order: "+a" stores chunk a, "a" reads it
+		steps  []string
+		cached string
+	}{
+		{name: "stored", steps: []string{"+a", "+b"}, cached: "ab"},
+		{name: "least recently stored evicted", steps: []string{"+a", "+b", "+c"}, cached: "bc"},
+		{name: "read keeps a chunk", steps: []string{"+a", "+b", "a", "+c"}, cached: "ac"},
+		{name: "stored twice", steps: []string{"+a", "+a", "+b"}, cached: "ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testChunkCache(t, t.TempDir())
+			for _, step := range tt.steps {
+				if key, ok := strings.CutPrefix(step, "+"); ok {
+					c.Put(key, chunk(key[0]))
+				} else {
+					c.Get(step)
+				}
+			}
+			for _, key := range []string{"a", "b", "c"} {
+				data, ok := c.Get(key)
+				want := strings.Contains(tt.cached, key)
+				if ok != want {
+					t.Fatalf("chunk %s cached: %v, want %v", key, ok, want)
+				}
+				if ok && !bytes.Equal(data, chunk(key[0])) {
+					t.Fatalf("chunk %s changed", key)
+				}
+			}
+			if c.size > c.maxBytes {
+				t.Fatalf("cache holds %d bytes, more than %d", c.size, c.maxBytes)
+			}
+		})
+	}
+}
+
+func TestChunkCacheReload(t *testing.T) {
+	dir := t.TempDir()
+	c := testChunkCache(t, dir)
+	c.Put("a", []byte("first"))
+	c.Put("big", make([]byte, c.maxBytes+1))
+	if err := os.WriteFile(filepath.Join(dir, "b.123.tmp"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c = testChunkCache(t, dir)
+	if data, ok := c.Get("a"); !ok || string(data) != "first" {
+		t.Fatalf("Get after reload = %q, %v", data, ok)
+	}
+	if _, ok := c.Get("big"); ok {
+		t.Fatal("a chunk larger than the cache was stored")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.123.tmp")); !os.IsNotExist(err) {
+		t.Fatal("an unfinished chunk file was kept")
+	}
+
+	// files removed behind the cache's back are dropped
+	os.Remove(c.path("a"))
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("Get returned a removed chunk")
+	}
+	if len(c.entries) != 0 || c.size != 0 {
+		t.Fatalf("cache still counts %d chunks, %d bytes", len(c.entries), c.size)
+	}
+}
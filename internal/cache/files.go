@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/types"
+	"errors"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var ErrFileNotFound = errors.New("file not found")
+
+// FileStore is the index of every file forwarded to the log channel.
+type FileStore struct {
+	db  *gorm.DB
+	log *zap.Logger
+}
+
+var fileStore *FileStore
+
+func InitFiles(log *zap.Logger) {
+	log = log.Named("files")
+	defer log.Sugar().Info("Initialized file index")
+
+	db := database.GetDB()
+	if db == nil {
+		log.Error("Database not initialized")
+		return
+	}
+
+	fileStore = &FileStore{
+		db:  db,
+		log: log,
+	}
+}
+
+// GetFiles returns the file index, or nil when the database is not
+// available.
+func GetFiles() *FileStore {
+	return fileStore
+}
+
+// Create adds a file to the index and fills in its ID.
+func (fs *FileStore) Create(record *types.FileRecord) error {
+	return fs.db.Create(record).Error
+}
+
+// ByMessage returns the oldest record of a log channel message.
+func (fs *FileStore) ByMessage(messageID int) (*types.FileRecord, error) {
+	var record types.FileRecord
+	err := fs.db.Where("message_id = ?", messageID).Order("id").First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Get returns a record by its ID.
+func (fs *FileStore) Get(id uint) (*types.FileRecord, error) {
+	var record types.FileRecord
+	err := fs.db.First(&record, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// ByUploader returns a page of the files in the history of a user, newest
+// first, and how many there are in total.
+func (fs *FileStore) ByUploader(userID int64, offset int, limit int) ([]types.FileRecord, int64, error) {
+	var total int64
+	err := fs.db.Model(&types.FileRecord{}).Where("uploader_id = ? AND hidden = ?", userID, false).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	var records []types.FileRecord
+	err = fs.db.Where("uploader_id = ? AND hidden = ?", userID, false).Order("id DESC").Offset(offset).Limit(limit).Find(&records).Error
+	return records, total, err
+}
+
+// Rename sets the name a record is shown with in the history.
+func (fs *FileStore) Rename(id uint, name string) error {
+	return fs.db.Model(&types.FileRecord{}).Where("id = ?", id).Update("display_name", name).Error
+}
+
+// Delete removes a record from the history.
+func (fs *FileStore) Delete(id uint) error {
+	return fs.db.Delete(&types.FileRecord{}, id).Error
+}
+
+// LatestByDocument returns the newest record of a Telegram document or
+// photo.
+func (fs *FileStore) LatestByDocument(documentID int64) (*types.FileRecord, error) {
+	var record types.FileRecord
+	err := fs.db.Where("document_id = ?", documentID).Order("id DESC").First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// CountByMessage returns how many records share a log channel message.
+func (fs *FileStore) CountByMessage(messageID int) (int64, error) {
+	var count int64
+	err := fs.db.Model(&types.FileRecord{}).Where("message_id = ?", messageID).Count(&count).Error
+	return count, err
+}
+
+// IsUploader reports whether a user sent the file of a log channel message.
+func (fs *FileStore) IsUploader(messageID int, userID int64) (bool, error) {
+	var count int64
+	err := fs.db.Model(&types.FileRecord{}).
+		Where("message_id = ? AND uploader_id = ?", messageID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// MarkGone records that a log channel message was deleted, so its records
+// no longer stand in for it.
+func (fs *FileStore) MarkGone(messageID int) error {
+	return fs.db.Model(&types.FileRecord{}).Where("message_id = ?", messageID).Update("gone", true).Error
+}
+
+// UpdateReference stores a fresh file reference for every record of a log
+// channel message.
+func (fs *FileStore) UpdateReference(messageID int, fileReference []byte) error {
+	return fs.db.Model(&types.FileRecord{}).
+		Where("message_id = ?", messageID).
+		Update("file_reference", fileReference).Error
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/types"
+	"errors"
+	"sync"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var ErrLinkNotFound = errors.New("link not found")
+
+// LinkStore keeps the usage counters of links with limits. Links are loaded
+// from the database on first use and kept in memory, so streams can check
+// their limits on every write.
+type LinkStore struct {
+	db    *gorm.DB
+	log   *zap.Logger
+	mu    sync.Mutex
+	links map[uint]*types.Link
+}
+
+var linkStore *LinkStore
+
+func InitLinks(log *zap.Logger) {
+	log = log.Named("links")
+	defer log.Sugar().Info("Initialized link store")
+
+	db := database.GetDB()
+	if db == nil {
+		log.Error("Database not initialized")
+		return
+	}
+
+	linkStore = &LinkStore{
+		db:    db,
+		log:   log,
+		links: make(map[uint]*types.Link),
+	}
+}
+
+// GetLinks returns the link store, or nil when the database is not
+// available.
+func GetLinks() *LinkStore {
+	return linkStore
+}
+
+// Create stores a new link and fills in its ID.
+func (ls *LinkStore) Create(link *types.Link) error {
+	if err := ls.db.Create(link).Error; err != nil {
+		return err
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	stored := *link
+	ls.links[link.ID] = &stored
+	return nil
+}
+
+// get must be called with ls.mu held.
+func (ls *LinkStore) get(id uint) (*types.Link, error) {
+	if link, ok := ls.links[id]; ok {
+		return link, nil
+	}
+	var link types.Link
+	err := ls.db.First(&link, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	ls.links[id] = &link
+	return &link, nil
+}
+
+// Get returns a copy of a link with its current counters.
+func (ls *LinkStore) Get(id uint) (*types.Link, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	link, err := ls.get(id)
+	if err != nil {
+		return nil, err
+	}
+	copied := *link
+	return &copied, nil
+}
+
+// AddBytes counts bytes served through a link and reports whether the link
+// is used up afterwards.
+func (ls *LinkStore) AddBytes(id uint, n int64) (bool, error) {
+	return ls.add(id, "bytes_served", n, func(link *types.Link) { link.BytesServed += n })
+}
+
+// AddDownload counts a complete download of the file of a link.
+func (ls *LinkStore) AddDownload(id uint) (bool, error) {
+	return ls.add(id, "downloads", 1, func(link *types.Link) { link.Downloads++ })
+}
+
+func (ls *LinkStore) add(id uint, column string, n int64, apply func(link *types.Link)) (bool, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	link, err := ls.get(id)
+	if err != nil {
+		return false, err
+	}
+	err = ls.db.Model(&types.Link{}).Where("id = ?", id).
+		UpdateColumn(column, gorm.Expr(column+" + ?", n)).Error
+	if err != nil {
+		ls.log.Error("Failed to update link usage", zap.Uint("id", id), zap.Error(err))
+		return link.Exhausted(), err
+	}
+	apply(link)
+	return link.Exhausted(), nil
+}
+
+// Exhausted reports whether a link has used up one of its limits.
+func (ls *LinkStore) Exhausted(id uint) (bool, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	link, err := ls.get(id)
+	if err != nil {
+		return false, err
+	}
+	return link.Exhausted(), nil
+}
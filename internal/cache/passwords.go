@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/types"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// passwordCheckTTL is how long a correct password is remembered, so media
+// players sending it with every range request don't pay for bcrypt each time.
+const passwordCheckTTL = 10 * time.Minute
+
+type passwordCheck struct {
+	messageID int
+	sum       [sha256.Size]byte
+}
+
+// PasswordStore keeps the password hashes of protected messages in memory.
+type PasswordStore struct {
+	db      *gorm.DB
+	log     *zap.Logger
+	mu      sync.RWMutex
+	hashes  map[int][]byte
+	checked map[passwordCheck]time.Time
+}
+
+var passwordStore *PasswordStore
+
+func InitPasswords(log *zap.Logger) {
+	log = log.Named("passwords")
+
+	db := database.GetDB()
+	if db == nil {
+		log.Error("Database not initialized")
+		return
+	}
+
+	var passwords []types.LinkPassword
+	if err := db.Find(&passwords).Error; err != nil {
+		log.Error("Failed to load passwords", zap.Error(err))
+		return
+	}
+	store := &PasswordStore{
+		db:      db,
+		log:     log,
+		hashes:  make(map[int][]byte),
+		checked: make(map[passwordCheck]time.Time),
+	}
+	for _, password := range passwords {
+		store.hashes[password.MessageID] = []byte(password.Hash)
+	}
+	passwordStore = store
+	log.Sugar().Infof("Initialized with %d protected files", len(passwords))
+}
+
+// GetPasswords returns the password store, or nil when the database is not
+// available.
+func GetPasswords() *PasswordStore {
+	return passwordStore
+}
+
+// Protected reports whether the links of a message need a password.
+func (ps *PasswordStore) Protected(messageID int) bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	_, ok := ps.hashes[messageID]
+	return ok
+}
+
+// Set protects the links of a message with a password, replacing the
+// previous one.
+func (ps *PasswordStore) Set(messageID int, password string, setBy int64) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	record := types.LinkPassword{MessageID: messageID, Hash: string(hash), SetBy: setBy}
+	err = ps.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "message_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"hash", "set_by", "updated_at"}),
+	}).Create(&record).Error
+	if err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.hashes[messageID] = hash
+	ps.forget(messageID)
+	return nil
+}
+
+// Remove makes the links of a message work without a password again.
+func (ps *PasswordStore) Remove(messageID int) error {
+	if err := ps.db.Delete(&types.LinkPassword{}, "message_id = ?", messageID).Error; err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	delete(ps.hashes, messageID)
+	ps.forget(messageID)
+	return nil
+}
+
+// forget must be called with ps.mu held.
+func (ps *PasswordStore) forget(messageID int) {
+	for check := range ps.checked {
+		if check.messageID == messageID {
+			delete(ps.checked, check)
+		}
+	}
+}
+
+// Check compares a password with the one of a message. Messages without a
+// password accept any.
+func (ps *PasswordStore) Check(messageID int, password string) bool {
+	check := passwordCheck{messageID: messageID, sum: sha256.Sum256([]byte(password))}
+	ps.mu.RLock()
+	hash, protected := ps.hashes[messageID]
+	checkedAt, checked := ps.checked[check]
+	ps.mu.RUnlock()
+	if !protected {
+		return true
+	}
+	if checked && time.Since(checkedAt) < passwordCheckTTL {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		ps.log.Debug("Wrong password", zap.Int("messageID", messageID))
+		return false
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for key, at := range ps.checked {
+		if time.Since(at) >= passwordCheckTTL {
+			delete(ps.checked, key)
+		}
+	}
+	ps.checked[check] = time.Now()
+	return true
+}
@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/types"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotRevoked           = errors.New("not revoked")
+	ErrLegacyWithoutMessage = errors.New("legacy hashes are the same for every copy of a file, send the whole link to revoke one")
+)
+
+// TokenDigestSize is the length of a TokenDigest.
+const TokenDigestSize = 8
+
+// TokenDigest stands in for a link token where the token itself would be too
+// long, like in the bundles made of several links.
+func TokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return string(sum[:TokenDigestSize])
+}
+
+// RevocationStore keeps the revocations table in memory, so every request can
+// be checked without a database query.
+type RevocationStore struct {
+	db       *gorm.DB
+	log      *zap.Logger
+	mu       sync.RWMutex
+	messages map[int]struct{}
+	tokens   map[string]struct{}
+	digests  map[string]struct{}
+}
+
+var revocationStore *RevocationStore
+
+func InitRevocations(log *zap.Logger) {
+	log = log.Named("revocations")
+
+	db := database.GetDB()
+	if db == nil {
+		log.Error("Database not initialized")
+		return
+	}
+
+	var revocations []types.Revocation
+	if err := db.Find(&revocations).Error; err != nil {
+		log.Error("Failed to load revocations", zap.Error(err))
+		return
+	}
+	store := &RevocationStore{
+		db:       db,
+		log:      log,
+		messages: make(map[int]struct{}),
+		tokens:   make(map[string]struct{}),
+		digests:  make(map[string]struct{}),
+	}
+	for _, revocation := range revocations {
+		store.add(&revocation)
+	}
+	revocationStore = store
+	log.Sugar().Infof("Initialized with %d revocations", len(revocations))
+}
+
+// GetRevocations returns the revocation store, or nil when the database is
+// not available.
+func GetRevocations() *RevocationStore {
+	return revocationStore
+}
+
+// isLegacyToken tells legacy link hashes apart from signed tokens, which
+// always have a MAC after a dot.
+func isLegacyToken(token string) bool {
+	return token != "" && !strings.Contains(token, ".")
+}
+
+// tokenKey is how a revoked token, or its TokenDigest, is stored. A legacy
+// hash is the same for every message of a file, so it is only revoked for
+// its own message, while signed tokens are bound to their message already.
+func tokenKey(messageID int, token string, legacy bool) string {
+	if legacy {
+		return strconv.Itoa(messageID) + ":" + token
+	}
+	return token
+}
+
+func (rs *RevocationStore) add(revocation *types.Revocation) {
+	if token := revocation.Token; token != "" {
+		legacy := isLegacyToken(token)
+		rs.tokens[tokenKey(revocation.MessageID, token, legacy)] = struct{}{}
+		rs.digests[tokenKey(revocation.MessageID, TokenDigest(token), legacy)] = struct{}{}
+	} else {
+		rs.messages[revocation.MessageID] = struct{}{}
+	}
+}
+
+// IsRevoked reports whether a message, or the link with the given token,
+// has been revoked.
+func (rs *RevocationStore) IsRevoked(messageID int, token string) bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	if _, ok := rs.messages[messageID]; ok {
+		return true
+	}
+	_, ok := rs.tokens[tokenKey(messageID, token, isLegacyToken(token))]
+	return ok && token != ""
+}
+
+// IsDigestRevoked reports whether the link of a message whose token has the
+// given TokenDigest has been revoked.
+func (rs *RevocationStore) IsDigestRevoked(messageID int, digest string) bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	if _, ok := rs.digests[digest]; ok {
+		return true
+	}
+	_, ok := rs.digests[tokenKey(messageID, digest, true)]
+	return ok
+}
+
+// Revoke stores a revocation of a message, or of a single link when
+// revocation.Token is set. Revoking twice is a no-op.
+func (rs *RevocationStore) Revoke(revocation *types.Revocation) error {
+	if isLegacyToken(revocation.Token) && revocation.MessageID == 0 {
+		return ErrLegacyWithoutMessage
+	}
+	if rs.has(revocation) {
+		return nil
+	}
+	if err := rs.db.Create(revocation).Error; err != nil {
+		return err
+	}
+	rs.mu.Lock()
+	rs.add(revocation)
+	rs.mu.Unlock()
+	rs.log.Info("Revoked", zap.Int("messageID", revocation.MessageID), zap.String("token", revocation.Token), zap.Int64("by", revocation.RevokedBy))
+	return nil
+}
+
+func (rs *RevocationStore) has(revocation *types.Revocation) bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	if token := revocation.Token; token != "" {
+		_, ok := rs.tokens[tokenKey(revocation.MessageID, token, isLegacyToken(token))]
+		return ok
+	}
+	_, ok := rs.messages[revocation.MessageID]
+	return ok
+}
+
+// Unrevoke removes the revocation of a message, or of a single link when
+// token is set. It returns ErrNotRevoked when there was none.
+func (rs *RevocationStore) Unrevoke(messageID int, token string) error {
+	legacy := isLegacyToken(token)
+	if legacy && messageID == 0 {
+		return ErrLegacyWithoutMessage
+	}
+	query := rs.db.Where("token = ?", token)
+	if token == "" || legacy {
+		query = rs.db.Where("message_id = ? AND token = ?", messageID, token)
+	}
+	result := query.Delete(&types.Revocation{})
+	if result.Error != nil {
+		return result.Error
+	}
+	rs.mu.Lock()
+	if token != "" {
+		delete(rs.tokens, tokenKey(messageID, token, legacy))
+		delete(rs.digests, tokenKey(messageID, TokenDigest(token), legacy))
+	} else {
+		delete(rs.messages, messageID)
+	}
+	rs.mu.Unlock()
+	if result.RowsAffected == 0 {
+		return ErrNotRevoked
+	}
+	rs.log.Info("Unrevoked", zap.Int("messageID", messageID), zap.String("token", token))
+	return nil
+}
+
+// List returns every revocation, newest first.
+func (rs *RevocationStore) List() ([]types.Revocation, error) {
+	var revocations []types.Revocation
+	err := rs.db.Order("created_at DESC").Find(&revocations).Error
+	return revocations, err
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"EverythingSuckz/fsb/internal/types"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func testRevocations(t *testing.T) *RevocationStore {
+	t.Helper()
+	testDB(t, &types.Revocation{})
+	InitRevocations(zap.NewNop())
+	return GetRevocations()
+}
+
+func TestRevocations(t *testing.T) {
+	const (
+		signed = "AQA.mac"
+		other  = "AQA.other"
+		legacy = "abc123"
+	)
+	tests := []struct {
+		name      string
+		revoke    types.Revocation
+		messageID int
+		token     string
+		revoked   bool
+	}{
+		{name: "message", revoke: types.Revocation{MessageID: 1}, messageID: 1, token: signed, revoked: true},
+		{name: "message, other message", revoke: types.Revocation{MessageID: 1}, messageID: 2, token: signed},
+		{name: "token", revoke: types.Revocation{Token: signed}, messageID: 1, token: signed, revoked: true},
+		{name: "token, other token", revoke: types.Revocation{Token: signed}, messageID: 1, token: other},
+		{name: "token, no token", revoke: types.Revocation{Token: signed}, messageID: 1},
+		{name: "legacy hash", revoke: types.Revocation{MessageID: 1, Token: legacy}, messageID: 1, token: legacy, revoked: true},
+		// every copy of a file has the same legacy hash
+		{name: "legacy hash, other message", revoke: types.Revocation{MessageID: 1, Token: legacy}, messageID: 2, token: legacy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revocations := testRevocations(t)
+			if err := revocations.Revoke(&tt.revoke); err != nil {
+				t.Fatal(err)
+			}
+			if revoked := revocations.IsRevoked(tt.messageID, tt.token); revoked != tt.revoked {
+				t.Fatalf("IsRevoked = %v, want %v", revoked, tt.revoked)
+			}
+			if tt.token == "" {
+				return
+			}
+			if revoked := revocations.IsDigestRevoked(tt.messageID, TokenDigest(tt.token)); revoked != (tt.revoked && tt.revoke.Token != "") {
+				t.Fatalf("IsDigestRevoked = %v", revoked)
+			}
+
+			// revocations survive a restart
+			InitRevocations(zap.NewNop())
+			if revoked := GetRevocations().IsRevoked(tt.messageID, tt.token); revoked != tt.revoked {
+				t.Fatalf("IsRevoked after loading = %v, want %v", revoked, tt.revoked)
+			}
+		})
+	}
+}
+
+func TestLegacyRevocations(t *testing.T) {
+	revocations := testRevocations(t)
+	if err := revocations.Revoke(&types.Revocation{Token: "abc123"}); !errors.Is(err, ErrLegacyWithoutMessage) {
+		t.Fatalf("Revoke without message ID error = %v, want ErrLegacyWithoutMessage", err)
+	}
+	if err := revocations.Unrevoke(0, "abc123"); !errors.Is(err, ErrLegacyWithoutMessage) {
+		t.Fatalf("Unrevoke without message ID error = %v, want ErrLegacyWithoutMessage", err)
+	}
+
+	for _, messageID := range []int{1, 2} {
+		if err := revocations.Revoke(&types.Revocation{MessageID: messageID, Token: "abc123"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := revocations.Unrevoke(1, "abc123"); err != nil {
+		t.Fatal(err)
+	}
+	if revocations.IsRevoked(1, "abc123") || !revocations.IsRevoked(2, "abc123") {
+		t.Fatal("unrevoking a legacy hash must only apply to its message")
+	}
+	if err := revocations.Unrevoke(1, "abc123"); !errors.Is(err, ErrNotRevoked) {
+		t.Fatalf("second Unrevoke error = %v, want ErrNotRevoked", err)
+	}
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/types"
+	"errors"
+	"sync"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSlugNotFound = errors.New("short link not found")
+	ErrSlugTaken    = errors.New("short link is already taken")
+)
+
+// SlugStore resolves short links. Slugs are loaded from the database on
+// first use and kept in memory.
+type SlugStore struct {
+	db    *gorm.DB
+	log   *zap.Logger
+	mu    sync.Mutex
+	slugs map[string]*types.Slug
+}
+
+var slugStore *SlugStore
+
+func InitSlugs(log *zap.Logger) {
+	log = log.Named("slugs")
+	defer log.Sugar().Info("Initialized slug store")
+
+	db := database.GetDB()
+	if db == nil {
+		log.Error("Database not initialized")
+		return
+	}
+
+	slugStore = &SlugStore{
+		db:    db,
+		log:   log,
+		slugs: make(map[string]*types.Slug),
+	}
+}
+
+// GetSlugs returns the slug store, or nil when the database is not
+// available.
+func GetSlugs() *SlugStore {
+	return slugStore
+}
+
+// Create stores a new slug, failing with ErrSlugTaken when it is in use.
+func (ss *SlugStore) Create(slug *types.Slug) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	result := ss.db.Clauses(clause.OnConflict{DoNothing: true}).Create(slug)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSlugTaken
+	}
+	stored := *slug
+	ss.slugs[slug.Slug] = &stored
+	return nil
+}
+
+// Get resolves a slug.
+func (ss *SlugStore) Get(name string) (*types.Slug, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if slug, ok := ss.slugs[name]; ok {
+		copied := *slug
+		return &copied, nil
+	}
+	var slug types.Slug
+	err := ss.db.First(&slug, "slug = ?", name).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSlugNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	stored := slug
+	ss.slugs[name] = &stored
+	return &slug, nil
+}
@@ -4,15 +4,24 @@ import (
 	"EverythingSuckz/fsb/internal/database"
 	"EverythingSuckz/fsb/internal/types"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"gorm.io/gorm"
 	"go.uber.org/zap"
 )
 
+// hotlinkFlushInterval is how often the blocked hotlinks counted in memory
+// are added to the database.
+const hotlinkFlushInterval = time.Minute
+
 type StatsCache struct {
 	db  *gorm.DB
 	log *zap.Logger
+	// mu keeps concurrent first writes of a day from creating two rows
+	mu              sync.Mutex
+	hotlinksBlocked atomic.Int64
 }
 
 var statsCache *StatsCache
@@ -31,36 +40,72 @@ func InitStatsCache(log *zap.Logger) {
 		db:  db,
 		log: log,
 	}
+	go statsCache.flushHotlinksEvery(hotlinkFlushInterval)
 }
 
 func GetStatsCache() *StatsCache {
 	return statsCache
 }
 
-// RecordFileProcessed records a file processing event
-func (sc *StatsCache) RecordFileProcessed(fileSize int64) error {
+// addToday adds to the counters of today's record, creating it when needed
+func (sc *StatsCache) addToday(fileCount, totalSize, hotlinksBlocked int64) error {
 	today := time.Now().Truncate(24 * time.Hour)
-	
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
 	var stats types.Stats
 	result := sc.db.Where("date = ?", today).First(&stats)
-	
+
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			// Create new record for today
 			stats = types.Stats{
-				Date:      today,
-				FileCount: 1,
-				TotalSize: fileSize,
+				Date:            today,
+				FileCount:       fileCount,
+				TotalSize:       totalSize,
+				HotlinksBlocked: hotlinksBlocked,
 			}
 			return sc.db.Create(&stats).Error
 		}
 		return result.Error
 	}
-	
-	// Update existing record
-	stats.FileCount++
-	stats.TotalSize += fileSize
-	return sc.db.Save(&stats).Error
+
+	// Update existing record, without overwriting the other counters
+	return sc.db.Model(&stats).Updates(map[string]interface{}{
+		"file_count":       gorm.Expr("file_count + ?", fileCount),
+		"total_size":       gorm.Expr("total_size + ?", totalSize),
+		"hotlinks_blocked": gorm.Expr("hotlinks_blocked + ?", hotlinksBlocked),
+	}).Error
+}
+
+// RecordFileProcessed records a file processing event
+func (sc *StatsCache) RecordFileProcessed(fileSize int64) error {
+	return sc.addToday(1, fileSize, 0)
+}
+
+// RecordHotlinkBlocked counts a request refused by the hotlink protection.
+// Blocked requests can come in floods, so they are counted in memory and
+// added to the database every hotlinkFlushInterval.
+func (sc *StatsCache) RecordHotlinkBlocked() {
+	sc.hotlinksBlocked.Add(1)
+}
+
+func (sc *StatsCache) flushHotlinksEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		sc.flushHotlinks()
+	}
+}
+
+func (sc *StatsCache) flushHotlinks() {
+	n := sc.hotlinksBlocked.Swap(0)
+	if n == 0 {
+		return
+	}
+	if err := sc.addToday(0, 0, n); err != nil {
+		sc.log.Error("Failed to record blocked hotlinks", zap.Error(err))
+		sc.hotlinksBlocked.Add(n)
+	}
 }
 
 // GetTodayStats returns today's statistics
@@ -82,9 +127,10 @@ func (sc *StatsCache) GetTodayStats() (types.DailyStats, error) {
 	}
 	
 	return types.DailyStats{
-		Date:      stats.Date,
-		FileCount: stats.FileCount,
-		TotalSize: stats.TotalSize,
+		Date:            stats.Date,
+		FileCount:       stats.FileCount,
+		TotalSize:       stats.TotalSize,
+		HotlinksBlocked: stats.HotlinksBlocked,
 	}, nil
 }
 
@@ -107,9 +153,10 @@ func (sc *StatsCache) GetYesterdayStats() (types.DailyStats, error) {
 	}
 	
 	return types.DailyStats{
-		Date:      stats.Date,
-		FileCount: stats.FileCount,
-		TotalSize: stats.TotalSize,
+		Date:            stats.Date,
+		FileCount:       stats.FileCount,
+		TotalSize:       stats.TotalSize,
+		HotlinksBlocked: stats.HotlinksBlocked,
 	}, nil
 }
 
@@ -119,12 +166,13 @@ func (sc *StatsCache) GetLastWeekStats() (types.WeeklyStats, error) {
 	startDate := endDate.AddDate(0, 0, -7)
 	
 	var result struct {
-		FileCount int64 `gorm:"column:file_count"`
-		TotalSize int64 `gorm:"column:total_size"`
+		FileCount       int64 `gorm:"column:file_count"`
+		TotalSize       int64 `gorm:"column:total_size"`
+		HotlinksBlocked int64 `gorm:"column:hotlinks_blocked"`
 	}
 	
 	err := sc.db.Model(&types.Stats{}).
-		Select("COALESCE(SUM(file_count), 0) as file_count, COALESCE(SUM(total_size), 0) as total_size").
+		Select("COALESCE(SUM(file_count), 0) as file_count, COALESCE(SUM(total_size), 0) as total_size, COALESCE(SUM(hotlinks_blocked), 0) as hotlinks_blocked").
 		Where("date >= ? AND date < ?", startDate, endDate).
 		Scan(&result).Error
 	
@@ -133,22 +181,24 @@ func (sc *StatsCache) GetLastWeekStats() (types.WeeklyStats, error) {
 	}
 	
 	return types.WeeklyStats{
-		StartDate: startDate,
-		EndDate:   endDate,
-		FileCount: result.FileCount,
-		TotalSize: result.TotalSize,
+		StartDate:       startDate,
+		EndDate:         endDate,
+		FileCount:       result.FileCount,
+		TotalSize:       result.TotalSize,
+		HotlinksBlocked: result.HotlinksBlocked,
 	}, nil
 }
 
 // GetTotalStats returns all-time statistics
 func (sc *StatsCache) GetTotalStats() (types.DailyStats, error) {
 	var result struct {
-		FileCount int64 `gorm:"column:file_count"`
-		TotalSize int64 `gorm:"column:total_size"`
+		FileCount       int64 `gorm:"column:file_count"`
+		TotalSize       int64 `gorm:"column:total_size"`
+		HotlinksBlocked int64 `gorm:"column:hotlinks_blocked"`
 	}
 	
 	err := sc.db.Model(&types.Stats{}).
-		Select("COALESCE(SUM(file_count), 0) as file_count, COALESCE(SUM(total_size), 0) as total_size").
+		Select("COALESCE(SUM(file_count), 0) as file_count, COALESCE(SUM(total_size), 0) as total_size, COALESCE(SUM(hotlinks_blocked), 0) as hotlinks_blocked").
 		Scan(&result).Error
 	
 	if err != nil {
@@ -156,14 +206,17 @@ func (sc *StatsCache) GetTotalStats() (types.DailyStats, error) {
 	}
 	
 	return types.DailyStats{
-		Date:      time.Now(),
-		FileCount: result.FileCount,
-		TotalSize: result.TotalSize,
+		Date:            time.Now(),
+		FileCount:       result.FileCount,
+		TotalSize:       result.TotalSize,
+		HotlinksBlocked: result.HotlinksBlocked,
 	}, nil
 }
 
 // GetCompleteStats returns all statistics in one call
 func (sc *StatsCache) GetCompleteStats() (types.StatisticsResponse, error) {
+	sc.flushHotlinks()
+
 	today, err := sc.GetTodayStats()
 	if err != nil {
 		return types.StatisticsResponse{}, fmt.Errorf("failed to get today stats: %w", err)
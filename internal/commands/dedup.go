@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"errors"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+// findUpload looks for the log channel message of an earlier upload of the
+// document in media, so sending a file again doesn't forward it again. The
+// upload is always recorded for userID, so deleting it elsewhere doesn't
+// revoke the links userID got, and only shows in their history when
+// RECORD_DUPLICATE_UPLOADS is set. Files another user protected with a
+// password are sent again, so their password doesn't apply to the links of
+// userID.
+func findUpload(ctx *ext.Context, media tg.MessageMediaClass, userID int64) (int, *types.File, bool) {
+	files := cache.GetFiles()
+	if files == nil {
+		return 0, nil, false
+	}
+	sent, err := utils.FileFromMedia(media)
+	if err != nil {
+		return 0, nil, false
+	}
+	log := utils.Logger.Named("dedup")
+	record, err := files.LatestByDocument(sent.ID)
+	if err != nil {
+		if !errors.Is(err, cache.ErrFileNotFound) {
+			log.Error("Failed to look up document", zap.Int64("documentID", sent.ID), zap.Error(err))
+		}
+		return 0, nil, false
+	}
+	// revoked and deleted messages get a new copy with working links
+	if revocations := cache.GetRevocations(); revocations != nil && revocations.IsRevoked(record.MessageID, "") {
+		return 0, nil, false
+	}
+	if passwords := cache.GetPasswords(); passwords != nil && passwords.Protected(record.MessageID) && !isOwner(userID, record.MessageID) {
+		return 0, nil, false
+	}
+	file, err := utils.FreshFileFromContext(ctx, record.MessageID)
+	if err != nil {
+		log.Debug("Earlier upload is gone", zap.Int("messageID", record.MessageID), zap.Error(err))
+		return 0, nil, false
+	}
+	if file.ID != sent.ID {
+		return 0, nil, false
+	}
+	if uploader, err := files.IsUploader(record.MessageID, userID); err == nil && !uploader {
+		utils.IndexFile(record.MessageID, userID, file.Date, file, !config.ValueOf.RecordDuplicateUploads)
+	}
+	log.Debug("Reusing earlier upload", zap.Int("messageID", record.MessageID), zap.Int64("documentID", sent.ID))
+	return record.MessageID, file, true
+}
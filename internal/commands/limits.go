@@ -0,0 +1,141 @@
+package commands
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/utils"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/dispatcher/handlers/filters"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+const gigabyte = 1024 * 1024 * 1024
+
+// limitOption is one of the limits offered for a new limited link.
+type limitOption struct {
+	key          string
+	label        string
+	maxDownloads int64
+	maxBytes     int64
+}
+
+var limitOptions = []limitOption{
+	{key: "d1", label: "1 download", maxDownloads: 1},
+	{key: "d3", label: "3 downloads", maxDownloads: 3},
+	{key: "d10", label: "10 downloads", maxDownloads: 10},
+	{key: "g1", label: "1 GB", maxBytes: 1 * gigabyte},
+	{key: "g5", label: "5 GB", maxBytes: 5 * gigabyte},
+	{key: "g20", label: "20 GB", maxBytes: 20 * gigabyte},
+}
+
+func (m *command) LoadLimits(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("limits")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCallbackQuery(filters.CallbackQuery.Prefix("limit:"), limitCallback))
+}
+
+// limitButton is added to the reply of sendLink, when limited links can be
+// created.
+func limitButton(messageID int) *tg.KeyboardButtonCallback {
+	if cache.GetLinks() == nil {
+		return nil
+	}
+	return &tg.KeyboardButtonCallback{
+		Text: "⏳ Limited link",
+		Data: []byte(utils.SignCallback(fmt.Sprintf("limit:%d", messageID))),
+	}
+}
+
+// linkRows puts the button of every link in its own row.
+func linkRows(links []utils.Link) []tg.KeyboardButtonRow {
+	rows := make([]tg.KeyboardButtonRow, 0, len(links))
+	for _, link := range links {
+		rows = append(rows, tg.KeyboardButtonRow{
+			Buttons: []tg.KeyboardButtonClass{
+				&tg.KeyboardButtonURL{Text: link.Label, URL: link.URL},
+			},
+		})
+	}
+	return rows
+}
+
+func answerCallback(ctx *ext.Context, query *tg.UpdateBotCallbackQuery, text string) {
+	ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+		QueryID: query.QueryID,
+		Message: text,
+		Alert:   text != "",
+	})
+}
+
+// limitCallback handles limit:<messageID> by offering the limits, and
+// limit:<messageID>:<option> by creating the link.
+func limitCallback(ctx *ext.Context, u *ext.Update) error {
+	query := u.CallbackQuery
+	data, ok := utils.VerifyCallback(string(query.Data))
+	if !ok {
+		answerCallback(ctx, query, "This button is no longer valid.")
+		return dispatcher.EndGroups
+	}
+	parts := strings.Split(data, ":")
+	messageID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		answerCallback(ctx, query, "This button is no longer valid.")
+		return dispatcher.EndGroups
+	}
+	if !isOwner(query.UserID, messageID) {
+		answerCallback(ctx, query, "Only the user who sent this file can create limited links.")
+		return dispatcher.EndGroups
+	}
+
+	if len(parts) == 2 {
+		answerCallback(ctx, query, "")
+		var rows []tg.KeyboardButtonRow
+		for i := 0; i < len(limitOptions); i += 3 {
+			var row tg.KeyboardButtonRow
+			for _, option := range limitOptions[i:min(i+3, len(limitOptions))] {
+				row.Buttons = append(row.Buttons, &tg.KeyboardButtonCallback{
+					Text: option.label,
+					Data: []byte(utils.SignCallback(fmt.Sprintf("limit:%d:%s", messageID, option.key))),
+				})
+			}
+			rows = append(rows, row)
+		}
+		ctx.SendMessage(query.UserID, &tg.MessagesSendMessageRequest{
+			Message:     "Choose when the link should stop working:",
+			ReplyMarkup: &tg.ReplyInlineMarkup{Rows: rows},
+		})
+		return dispatcher.EndGroups
+	}
+
+	var option *limitOption
+	for i := range limitOptions {
+		if limitOptions[i].key == parts[2] {
+			option = &limitOptions[i]
+		}
+	}
+	if option == nil {
+		answerCallback(ctx, query, "Unknown limit.")
+		return dispatcher.EndGroups
+	}
+	file, err := utils.FileFromContext(ctx, messageID)
+	if err != nil {
+		answerCallback(ctx, query, fmt.Sprintf("Error - %s", err.Error()))
+		return dispatcher.EndGroups
+	}
+	claims, _, err := utils.NewLimitedLink(messageID, query.UserID, option.maxDownloads, option.maxBytes)
+	if err != nil {
+		answerCallback(ctx, query, fmt.Sprintf("Error - %s", err.Error()))
+		return dispatcher.EndGroups
+	}
+	answerCallback(ctx, query, "")
+	ctx.SendMessage(query.UserID, &tg.MessagesSendMessageRequest{
+		Message:     fmt.Sprintf("⏳ Limited link to %s, valid for %s:", file.FileName, option.label),
+		ReplyMarkup: &tg.ReplyInlineMarkup{Rows: linkRows(utils.FileLinks(messageID, file, claims))},
+	})
+	return dispatcher.EndGroups
+}
@@ -0,0 +1,272 @@
+package commands
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/dispatcher/handlers/filters"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	pendingRename  = "rename"
+	myFilesPerPage = 5
+	maxNameLength  = 128
+	// buttonNameLength keeps the file names on buttons short enough to read
+	buttonNameLength = 24
+)
+
+func (m *command) LoadMyFiles(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("myfiles")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("myfiles", myFiles))
+	dispatcher.AddHandler(handlers.NewCallbackQuery(filters.CallbackQuery.Prefix("mf:"), myFilesCallback))
+}
+
+func myFiles(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	if cache.GetFiles() == nil {
+		ctx.Reply(u, "❌ Your files are not available at the moment.", nil)
+		return dispatcher.EndGroups
+	}
+	text, markup, err := myFilesPage(chatId, 0)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, text, &ext.ReplyOpts{Markup: markup})
+	return dispatcher.EndGroups
+}
+
+func callbackButton(text string, data string) *tg.KeyboardButtonCallback {
+	return &tg.KeyboardButtonCallback{Text: text, Data: []byte(utils.SignCallback(data))}
+}
+
+func shortName(name string) string {
+	if utf8.RuneCountInString(name) <= buttonNameLength {
+		return name
+	}
+	return string([]rune(name)[:buttonNameLength-1]) + "…"
+}
+
+// myFilesPage lists a page of the files a user sent, newest first, with
+// buttons for each file and for the other pages. The markup is nil when
+// there are no files.
+func myFilesPage(userID int64, page int) (string, tg.ReplyMarkupClass, error) {
+	files := cache.GetFiles()
+	if files == nil {
+		return "", nil, errors.New("your files are not available at the moment")
+	}
+	records, total, err := files.ByUploader(userID, page*myFilesPerPage, myFilesPerPage)
+	if err != nil {
+		return "", nil, err
+	}
+	pages := int((total + myFilesPerPage - 1) / myFilesPerPage)
+	if len(records) == 0 && page > 0 {
+		// the last page is gone after deleting its files
+		return myFilesPage(userID, max(pages-1, 0))
+	}
+	if total == 0 {
+		return "You haven't sent any files yet.", nil, nil
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "📂 Your files (page %d of %d, %d files)\n\n", page+1, pages, total)
+	var rows []tg.KeyboardButtonRow
+	for i, record := range records {
+		n := page*myFilesPerPage + i + 1
+		fmt.Fprintf(&text, "%d. %s\n%s - %s\n\n", n, record.Name(), utils.FormatFileSizeShort(record.FileSize), record.CreatedAt.Format("2006-01-02 15:04"))
+		rows = append(rows, tg.KeyboardButtonRow{Buttons: []tg.KeyboardButtonClass{
+			callbackButton(fmt.Sprintf("🔗 %d. %s", n, shortName(record.Name())), fmt.Sprintf("mf:l:%d", record.ID)),
+			callbackButton("✏️", fmt.Sprintf("mf:r:%d", record.ID)),
+			callbackButton("🗑", fmt.Sprintf("mf:d:%d:%d", record.ID, page)),
+		}})
+	}
+	var nav tg.KeyboardButtonRow
+	if page > 0 {
+		nav.Buttons = append(nav.Buttons, callbackButton("« Previous", fmt.Sprintf("mf:p:%d", page-1)))
+	}
+	if page < pages-1 {
+		nav.Buttons = append(nav.Buttons, callbackButton("Next »", fmt.Sprintf("mf:p:%d", page+1)))
+	}
+	if len(nav.Buttons) > 0 {
+		rows = append(rows, nav)
+	}
+	return strings.TrimSpace(text.String()), &tg.ReplyInlineMarkup{Rows: rows}, nil
+}
+
+// myFilesCallback handles the buttons of /myfiles:
+//
+//	mf:p:<page>            shows another page
+//	mf:l:<id>              shows the links of a file again
+//	mf:r:<id>              asks for a new name
+//	mf:d:<id>:<page>       asks to confirm deleting a file
+//	mf:x:<id>:<page>       deletes it
+func myFilesCallback(ctx *ext.Context, u *ext.Update) error {
+	query := u.CallbackQuery
+	data, ok := utils.VerifyCallback(string(query.Data))
+	if !ok {
+		answerCallback(ctx, query, "This button is no longer valid.")
+		return dispatcher.EndGroups
+	}
+	parts := strings.Split(data, ":")
+	if len(parts) < 3 {
+		answerCallback(ctx, query, "This button is no longer valid.")
+		return dispatcher.EndGroups
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		answerCallback(ctx, query, "This button is no longer valid.")
+		return dispatcher.EndGroups
+	}
+	page := 0
+	if len(parts) > 3 {
+		page, _ = strconv.Atoi(parts[3])
+	}
+
+	if parts[1] == "p" {
+		answerCallback(ctx, query, "")
+		editMyFiles(ctx, query, id)
+		return dispatcher.EndGroups
+	}
+
+	files := cache.GetFiles()
+	if files == nil {
+		answerCallback(ctx, query, "Your files are not available at the moment.")
+		return dispatcher.EndGroups
+	}
+	record, err := files.Get(uint(id))
+	if err != nil || record.UploaderID != query.UserID {
+		answerCallback(ctx, query, "This file is no longer in your history.")
+		return dispatcher.EndGroups
+	}
+
+	switch parts[1] {
+	case "l":
+		file, err := utils.FileFromContext(ctx, record.MessageID)
+		if err != nil {
+			answerCallback(ctx, query, fmt.Sprintf("Error - %s", err.Error()))
+			return dispatcher.EndGroups
+		}
+		answerCallback(ctx, query, "")
+		shown := *file
+		shown.FileName = record.Name()
+		text, markup := fileReply(record.MessageID, &shown, isOwner(query.UserID, record.MessageID))
+		ctx.SendMessage(query.UserID, &tg.MessagesSendMessageRequest{Message: text, ReplyMarkup: markup})
+	case "r":
+		answerCallback(ctx, query, "")
+		setPendingInput(query.UserID, pendingRename, int(record.ID))
+		ctx.SendMessage(query.UserID, &tg.MessagesSendMessageRequest{
+			Message: fmt.Sprintf("Send the new name for %s. Send /cancel to keep it.", record.Name()),
+		})
+	case "d":
+		answerCallback(ctx, query, "")
+		prompt := "Delete %s from your files? Its links will stop working."
+		// deleteFile leaves the links to the other users who sent the file
+		if count, err := files.CountByMessage(record.MessageID); err == nil && count > 1 {
+			prompt = "Delete %s from your files? Other users sent the same file, so its links keep working."
+		}
+		ctx.EditMessage(query.UserID, &tg.MessagesEditMessageRequest{
+			ID:      query.MsgID,
+			Message: fmt.Sprintf(prompt, record.Name()),
+			ReplyMarkup: &tg.ReplyInlineMarkup{Rows: []tg.KeyboardButtonRow{{Buttons: []tg.KeyboardButtonClass{
+				callbackButton("🗑 Delete", fmt.Sprintf("mf:x:%d:%d", record.ID, page)),
+				callbackButton("Cancel", fmt.Sprintf("mf:p:%d", page)),
+			}}}},
+		})
+	case "x":
+		if err := deleteFile(record, query.UserID); err != nil {
+			answerCallback(ctx, query, fmt.Sprintf("Error - %s", err.Error()))
+			return dispatcher.EndGroups
+		}
+		answerCallback(ctx, query, "🗑 Deleted.")
+		editMyFiles(ctx, query, page)
+	default:
+		answerCallback(ctx, query, "This button is no longer valid.")
+	}
+	return dispatcher.EndGroups
+}
+
+// editMyFiles replaces the message of a /myfiles button with another page.
+func editMyFiles(ctx *ext.Context, query *tg.UpdateBotCallbackQuery, page int) {
+	text, markup, err := myFilesPage(query.UserID, page)
+	if err != nil {
+		text, markup = fmt.Sprintf("Error - %s", err.Error()), nil
+	}
+	ctx.EditMessage(query.UserID, &tg.MessagesEditMessageRequest{
+		ID:          query.MsgID,
+		Message:     text,
+		ReplyMarkup: markup,
+	})
+}
+
+// deleteFile removes a file from the history of its uploader. Its links are
+// revoked once no other user has the same upload in their history.
+func deleteFile(record *types.FileRecord, userID int64) error {
+	files := cache.GetFiles()
+	if err := files.Delete(record.ID); err != nil {
+		return err
+	}
+	remaining, err := files.CountByMessage(record.MessageID)
+	if err != nil {
+		return err
+	}
+	if remaining > 0 {
+		return nil
+	}
+	revocations := cache.GetRevocations()
+	if revocations == nil {
+		return errors.New("revocations are not available")
+	}
+	return revocations.Revoke(&types.Revocation{
+		MessageID: record.MessageID,
+		Reason:    "deleted by the uploader",
+		RevokedBy: userID,
+	})
+}
+
+// setRenameInput renames a file of the history after myFilesCallback asked
+// for the name.
+func setRenameInput(ctx *ext.Context, u *ext.Update, id uint, name string) error {
+	chatId := u.EffectiveChat().GetID()
+	if name == "" || utf8.RuneCountInString(name) > maxNameLength || strings.ContainsAny(name, "\r\n") {
+		setPendingInput(chatId, pendingRename, int(id))
+		ctx.Reply(u, fmt.Sprintf("The name needs 1 to %d characters on a single line, try again or send /cancel.", maxNameLength), nil)
+		return dispatcher.EndGroups
+	}
+	files := cache.GetFiles()
+	if files == nil {
+		ctx.Reply(u, "❌ Your files are not available at the moment.", nil)
+		return dispatcher.EndGroups
+	}
+	record, err := files.Get(id)
+	if err != nil || record.UploaderID != chatId {
+		ctx.Reply(u, "This file is no longer in your history.", nil)
+		return dispatcher.EndGroups
+	}
+	if err := files.Rename(id, name); err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, fmt.Sprintf("✏️ Renamed to %s.", name), nil)
+	return dispatcher.EndGroups
+}
@@ -0,0 +1,218 @@
+package commands
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/types"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// withStores backs the file index, the revocations and the short links
+// with an empty in-memory database.
+func withStores(t *testing.T) *cache.FileStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would get a database of its own
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&types.Revocation{}, &types.FileRecord{}, &types.Slug{}); err != nil {
+		t.Fatal(err)
+	}
+	old := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = old })
+	cache.InitFiles(zap.NewNop())
+	cache.InitRevocations(zap.NewNop())
+	cache.InitSlugs(zap.NewNop())
+	return cache.GetFiles()
+}
+
+func addRecord(t *testing.T, files *cache.FileStore, messageID int, uploaderID int64, hidden bool) *types.FileRecord {
+	t.Helper()
+	record := &types.FileRecord{MessageID: messageID, UploaderID: uploaderID, DocumentID: 99, Hidden: hidden}
+	if err := files.Create(record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestDeleteFile(t *testing.T) {
+	const messageID = 10
+	tests := []struct {
+		name    string
+		others  []bool // hidden flag of the records of other users
+		revoked bool
+	}{
+		{name: "only uploader", revoked: true},
+		{name: "other uploader", others: []bool{false}},
+		// RECORD_DUPLICATE_UPLOADS=false still relies on the links
+		{name: "deduplicated upload", others: []bool{true}},
+		{name: "several others", others: []bool{true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := withStores(t)
+			record := addRecord(t, files, messageID, 1, false)
+			for i, hidden := range tt.others {
+				addRecord(t, files, messageID, int64(2+i), hidden)
+			}
+			addRecord(t, files, messageID+1, 1, false)
+
+			if err := deleteFile(record, 1); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := files.Get(record.ID); !errors.Is(err, cache.ErrFileNotFound) {
+				t.Fatalf("record still there, error = %v", err)
+			}
+			if revoked := cache.GetRevocations().IsRevoked(messageID, ""); revoked != tt.revoked {
+				t.Fatalf("revoked = %v, want %v", revoked, tt.revoked)
+			}
+			if cache.GetRevocations().IsRevoked(messageID+1, "") {
+				t.Fatal("another message was revoked")
+			}
+		})
+	}
+}
+
+func TestHiddenRecords(t *testing.T) {
+	files := withStores(t)
+	addRecord(t, files, 10, 1, false)
+	addRecord(t, files, 10, 2, true)
+
+	records, total, err := files.ByUploader(2, 0, myFilesPerPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 0 || len(records) != 0 {
+		t.Fatalf("ByUploader = %d records, %d in total, want none", len(records), total)
+	}
+	if uploader, err := files.IsUploader(10, 2); err != nil || !uploader {
+		t.Fatalf("IsUploader = %v, %v, want the hidden record", uploader, err)
+	}
+}
+
+func TestIsOwner(t *testing.T) {
+	old := *config.ValueOf
+	t.Cleanup(func() { *config.ValueOf = old })
+	config.ValueOf.AdminUsers = []int64{100}
+
+	files := withStores(t)
+	addRecord(t, files, 10, 1, false)
+	addRecord(t, files, 10, 2, true)
+	addRecord(t, files, 10, 3, false)
+
+	tests := []struct {
+		name      string
+		userID    int64
+		messageID int
+		owner     bool
+	}{
+		{name: "first uploader", userID: 1, messageID: 10, owner: true},
+		{name: "deduplicated upload", userID: 2, messageID: 10},
+		{name: "later uploader", userID: 3, messageID: 10},
+		{name: "stranger", userID: 4, messageID: 10},
+		{name: "admin", userID: 100, messageID: 10, owner: true},
+		{name: "unknown message", userID: 1, messageID: 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if owner := isOwner(tt.userID, tt.messageID); owner != tt.owner {
+				t.Fatalf("isOwner = %v, want %v", owner, tt.owner)
+			}
+		})
+	}
+}
+
+func TestShortName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "video.mp4", want: "video.mp4"},
+		{name: "exactly twenty-four.mp4!", want: "exactly twenty-four.mp4!"},
+		{name: "a rather long file name.mp4", want: "a rather long file name…"},
+		{name: "видео с длинным названием.mp4", want: "видео с длинным названи…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shortName(tt.name); got != tt.want {
+				t.Fatalf("shortName = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMyFilesPage(t *testing.T) {
+	tests := []struct {
+		name  string
+		files int
+		page  int
+		text  string
+		rows  int
+		nav   []string
+	}{
+		{name: "no files", text: "You haven't sent any files yet."},
+		{name: "single page", files: 3, text: "page 1 of 1, 3 files", rows: 3},
+		{name: "first page", files: 7, text: "page 1 of 2, 7 files", rows: 5, nav: []string{"Next »"}},
+		{name: "last page", files: 7, page: 1, text: "page 2 of 2, 7 files", rows: 2, nav: []string{"« Previous"}},
+		{name: "middle page", files: 12, page: 1, text: "page 2 of 3, 12 files", rows: 5, nav: []string{"« Previous", "Next »"}},
+		// the files of the page were deleted in the meantime
+		{name: "page past the end", files: 7, page: 3, text: "page 2 of 2, 7 files", rows: 2, nav: []string{"« Previous"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := withStores(t)
+			for i := 0; i < tt.files; i++ {
+				record := &types.FileRecord{MessageID: 10 + i, UploaderID: 1, DocumentID: 99, FileName: fmt.Sprintf("file%d.mp4", i)}
+				if err := files.Create(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			addRecord(t, files, 100, 2, false)
+
+			text, markup, err := myFilesPage(1, tt.page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(text, tt.text) {
+				t.Fatalf("text %q doesn't contain %q", text, tt.text)
+			}
+			if tt.files == 0 {
+				if markup != nil {
+					t.Fatal("a user without files got buttons")
+				}
+				return
+			}
+			rows := markup.(*tg.ReplyInlineMarkup).Rows
+			if len(tt.nav) > 0 {
+				var nav []string
+				for _, button := range rows[len(rows)-1].Buttons {
+					nav = append(nav, button.(*tg.KeyboardButtonCallback).Text)
+				}
+				if strings.Join(nav, ", ") != strings.Join(tt.nav, ", ") {
+					t.Fatalf("navigation = %v, want %v", nav, tt.nav)
+				}
+				rows = rows[:len(rows)-1]
+			}
+			if len(rows) != tt.rows {
+				t.Fatalf("%d file rows, want %d", len(rows), tt.rows)
+			}
+		})
+	}
+}
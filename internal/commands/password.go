@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/utils"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/dispatcher/handlers/filters"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	pendingPassword   = "password"
+	minPasswordLength = 4
+)
+
+func (m *command) LoadPassword(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("password")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCallbackQuery(filters.CallbackQuery.Prefix("pw:"), passwordCallback))
+}
+
+// passwordButton is added to the reply of sendLink, when links can be
+// protected with a password.
+func passwordButton(messageID int) *tg.KeyboardButtonCallback {
+	if cache.GetPasswords() == nil {
+		return nil
+	}
+	return &tg.KeyboardButtonCallback{
+		Text: "🔑 Password",
+		Data: []byte(utils.SignCallback(fmt.Sprintf("pw:%d", messageID))),
+	}
+}
+
+// passwordCallback handles pw:<messageID> by asking for the new password,
+// and pw:<messageID>:rm by removing it.
+func passwordCallback(ctx *ext.Context, u *ext.Update) error {
+	query := u.CallbackQuery
+	data, ok := utils.VerifyCallback(string(query.Data))
+	if !ok {
+		answerCallback(ctx, query, "This button is no longer valid.")
+		return dispatcher.EndGroups
+	}
+	parts := strings.Split(data, ":")
+	messageID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		answerCallback(ctx, query, "This button is no longer valid.")
+		return dispatcher.EndGroups
+	}
+	if !isOwner(query.UserID, messageID) {
+		answerCallback(ctx, query, "Only the user who sent this file can change its password.")
+		return dispatcher.EndGroups
+	}
+	passwords := cache.GetPasswords()
+	if passwords == nil {
+		answerCallback(ctx, query, "Passwords are not available at the moment.")
+		return dispatcher.EndGroups
+	}
+
+	if len(parts) == 3 && parts[2] == "rm" {
+		if err := passwords.Remove(messageID); err != nil {
+			answerCallback(ctx, query, fmt.Sprintf("Error - %s", err.Error()))
+			return dispatcher.EndGroups
+		}
+		answerCallback(ctx, query, "🔓 The password has been removed.")
+		return dispatcher.EndGroups
+	}
+
+	answerCallback(ctx, query, "")
+	setPendingInput(query.UserID, pendingPassword, messageID)
+	request := &tg.MessagesSendMessageRequest{
+		Message: fmt.Sprintf("Send the password for the links of this file, at least %d characters. Send /cancel to keep the links as they are.", minPasswordLength),
+	}
+	if passwords.Protected(messageID) {
+		request.ReplyMarkup = &tg.ReplyInlineMarkup{Rows: []tg.KeyboardButtonRow{{
+			Buttons: []tg.KeyboardButtonClass{&tg.KeyboardButtonCallback{
+				Text: "🔓 Remove password",
+				Data: []byte(utils.SignCallback(fmt.Sprintf("pw:%d:rm", messageID))),
+			}},
+		}}}
+	}
+	ctx.SendMessage(query.UserID, request)
+	return dispatcher.EndGroups
+}
+
+// setPasswordInput stores the password sent after passwordCallback asked for
+// it. The message with the password is deleted from the chat.
+func setPasswordInput(ctx *ext.Context, u *ext.Update, messageID int, password string) error {
+	chatId := u.EffectiveChat().GetID()
+	// the file may have changed hands since the password was asked for
+	if !isOwner(chatId, messageID) {
+		ctx.DeleteMessages(chatId, []int{u.EffectiveMessage.ID})
+		ctx.SendMessage(chatId, &tg.MessagesSendMessageRequest{Message: "Only the user who sent this file can change its password."})
+		return dispatcher.EndGroups
+	}
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		setPendingInput(chatId, pendingPassword, messageID)
+		ctx.Reply(u, fmt.Sprintf("The password needs at least %d characters, try again or send /cancel.", minPasswordLength), nil)
+		return dispatcher.EndGroups
+	}
+	passwords := cache.GetPasswords()
+	if passwords == nil {
+		ctx.Reply(u, "❌ Passwords are not available at the moment.", nil)
+		return dispatcher.EndGroups
+	}
+	if err := passwords.Set(messageID, password, chatId); err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	ctx.DeleteMessages(chatId, []int{u.EffectiveMessage.ID})
+	ctx.SendMessage(chatId, &tg.MessagesSendMessageRequest{
+		Message: "🔑 The links of this file now ask for the password. Media players can send it with HTTP Basic auth, using any user name.",
+	})
+	return dispatcher.EndGroups
+}
@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/dispatcher/handlers/filters"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+)
+
+// pendingInputTTL is how long the bot waits for the reply to one of its
+// prompts.
+const pendingInputTTL = 5 * time.Minute
+
+// pendingInput is a prompt of the bot waiting for the user's next message.
+type pendingInput struct {
+	action  string
+	target  int // message or file record ID, depending on action
+	expires time.Time
+}
+
+var pendingInputs = struct {
+	sync.Mutex
+	inputs map[int64]pendingInput
+}{inputs: make(map[int64]pendingInput)}
+
+func setPendingInput(userID int64, action string, target int) {
+	pendingInputs.Lock()
+	defer pendingInputs.Unlock()
+	pendingInputs.inputs[userID] = pendingInput{
+		action:  action,
+		target:  target,
+		expires: time.Now().Add(pendingInputTTL),
+	}
+}
+
+func takePendingInput(userID int64) (pendingInput, bool) {
+	pendingInputs.Lock()
+	defer pendingInputs.Unlock()
+	input, ok := pendingInputs.inputs[userID]
+	delete(pendingInputs.inputs, userID)
+	return input, ok && time.Now().Before(input.expires)
+}
+
+func (m *command) LoadPendingInput(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("pending_input")
+	defer log.Sugar().Info("Loaded")
+	// runs before every other handler, so the reply to a prompt isn't taken
+	// for a command or a file
+	dispatcher.AddHandlerToGroup(handlers.NewMessage(filters.Message.Text, pendingInputHandler), -1)
+}
+
+func pendingInputHandler(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.ContinueGroups
+	}
+	input, ok := takePendingInput(chatId)
+	if !ok {
+		return dispatcher.ContinueGroups
+	}
+	text := strings.TrimSpace(u.EffectiveMessage.Text)
+	if text == "/cancel" {
+		ctx.Reply(u, "Cancelled.", nil)
+		return dispatcher.EndGroups
+	}
+	if strings.HasPrefix(text, "/") {
+		// any other command drops the prompt and is handled as usual
+		return dispatcher.ContinueGroups
+	}
+	switch input.action {
+	case pendingPassword:
+		return setPasswordInput(ctx, u, input.target, text)
+	case pendingSlug:
+		return setSlugInput(ctx, u, input.target, text)
+	case pendingRename:
+		return setRenameInput(ctx, u, uint(input.target), text)
+	}
+	return dispatcher.ContinueGroups
+}
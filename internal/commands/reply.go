@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"fmt"
+
+	"github.com/gotd/td/tg"
+)
+
+// fileReply builds the message sendLink replies with, which is shown again
+// from the history of /myfiles. The buttons managing the links of the file
+// are only added for its owner.
+func fileReply(messageID int, file *types.File, owner bool) (string, *tg.ReplyInlineMarkup) {
+	emoji := fileTypeEmoji(file.MimeType)
+	size := formatFileSize(file.FileSize)
+	message := fmt.Sprintf(
+		"%s File Name: %s\n\n%s File Type: %s\n\n💾 Size: %s\n\n⏳ @yoelbots",
+		emoji, file.FileName,
+		emoji, file.MimeType,
+		size,
+	)
+
+	// --- Botones añadidos debajo del canal ---
+	row1 := tg.KeyboardButtonRow{
+		Buttons: []tg.KeyboardButtonClass{
+			&tg.KeyboardButtonURL{Text: "📢 @yoelbots", URL: "https://t.me/yoelbots"},
+		},
+	}
+	row2 := tg.KeyboardButtonRow{
+		Buttons: []tg.KeyboardButtonClass{
+			&tg.KeyboardButtonURL{Text: "🎬 Películas y Series en Español", URL: "https://t.me/peligxg"},
+		},
+	}
+	rows := []tg.KeyboardButtonRow{row1, row2}
+
+	// one button for every enabled link kind
+	rows = append(rows, linkRows(utils.FileLinks(messageID, file, utils.DefaultLinkClaims()))...)
+	if !owner {
+		return message, &tg.ReplyInlineMarkup{Rows: rows}
+	}
+	var extra tg.KeyboardButtonRow
+	if button := limitButton(messageID); button != nil {
+		extra.Buttons = append(extra.Buttons, button)
+	}
+	if button := passwordButton(messageID); button != nil {
+		extra.Buttons = append(extra.Buttons, button)
+	}
+	if button := slugButton(messageID); button != nil {
+		extra.Buttons = append(extra.Buttons, button)
+	}
+	if len(extra.Buttons) > 0 {
+		rows = append(rows, extra)
+	}
+
+	return message, &tg.ReplyInlineMarkup{Rows: rows}
+}
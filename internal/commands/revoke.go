@@ -0,0 +1,130 @@
+package commands
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+)
+
+const revokeUsage = "Usage: /%s <message ID | link | hash> [reason]"
+
+func (m *command) LoadRevoke(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("revoke")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("revoke", revoke))
+	dispatcher.AddHandler(handlers.NewCommand("unrevoke", unrevoke))
+}
+
+func isAdmin(userID int64) bool {
+	return utils.Contains(config.ValueOf.AdminUsers, userID)
+}
+
+// isOwner reports whether a user may manage the file of a log channel
+// message, which admins may for every file and users for the ones they sent
+// first. Users who sent the same file again only share its links.
+func isOwner(userID int64, messageID int) bool {
+	if isAdmin(userID) {
+		return true
+	}
+	files := cache.GetFiles()
+	if files == nil {
+		return false
+	}
+	record, err := files.ByMessage(messageID)
+	return err == nil && record.UploaderID == userID
+}
+
+// adminArgs checks that a command was sent by an admin in a private chat and
+// returns its arguments. ok is false when the update has been handled.
+func adminArgs(ctx *ext.Context, u *ext.Update) (args []string, ok bool) {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return nil, false
+	}
+	if !isAdmin(chatId) {
+		ctx.Reply(u, "This command is only available to admins.", nil)
+		return nil, false
+	}
+	return strings.Fields(u.EffectiveMessage.Text)[1:], true
+}
+
+func revoke(ctx *ext.Context, u *ext.Update) error {
+	args, ok := adminArgs(ctx, u)
+	if !ok {
+		return dispatcher.EndGroups
+	}
+	if len(args) == 0 {
+		ctx.Reply(u, fmt.Sprintf(revokeUsage, "revoke"), nil)
+		return dispatcher.EndGroups
+	}
+	messageID, token, err := utils.ParseLinkTarget(args[0])
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	revocations := cache.GetRevocations()
+	if revocations == nil {
+		ctx.Reply(u, "❌ Revocations are not available at the moment.", nil)
+		return dispatcher.EndGroups
+	}
+	err = revocations.Revoke(&types.Revocation{
+		MessageID: messageID,
+		Token:     token,
+		Reason:    strings.Join(args[1:], " "),
+		RevokedBy: u.EffectiveChat().GetID(),
+	})
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	switch {
+	case token != "" && messageID != 0:
+		ctx.Reply(u, fmt.Sprintf("✅ The link has been revoked. The other links of the file keep working, revoke message %d to stop them all.", messageID), nil)
+	case token != "":
+		ctx.Reply(u, "✅ The link has been revoked. The other links of the file keep working.", nil)
+	default:
+		ctx.Reply(u, fmt.Sprintf("✅ Every link of message %d has been revoked.", messageID), nil)
+	}
+	return dispatcher.EndGroups
+}
+
+func unrevoke(ctx *ext.Context, u *ext.Update) error {
+	args, ok := adminArgs(ctx, u)
+	if !ok {
+		return dispatcher.EndGroups
+	}
+	if len(args) == 0 {
+		ctx.Reply(u, fmt.Sprintf(revokeUsage, "unrevoke"), nil)
+		return dispatcher.EndGroups
+	}
+	messageID, token, err := utils.ParseLinkTarget(args[0])
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	revocations := cache.GetRevocations()
+	if revocations == nil {
+		ctx.Reply(u, "❌ Revocations are not available at the moment.", nil)
+		return dispatcher.EndGroups
+	}
+	err = revocations.Unrevoke(messageID, token)
+	switch {
+	case errors.Is(err, cache.ErrNotRevoked):
+		ctx.Reply(u, "Nothing to unrevoke, it wasn't revoked.", nil)
+	case err != nil:
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+	default:
+		ctx.Reply(u, "✅ Revocation removed.", nil)
+	}
+	return dispatcher.EndGroups
+}
@@ -0,0 +1,169 @@
+package commands
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/utils"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/dispatcher/handlers/filters"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+)
+
+const (
+	pendingSlug   = "slug"
+	slugUsage     = "Usage: /slug <link | message ID> [slug]\nShort links can be made for files you sent. Without a slug a random one is picked."
+	slugOwnerOnly = "Only the user who sent this file can create short links for it."
+)
+
+func (m *command) LoadSlug(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("slug")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("slug", slugCommand))
+	dispatcher.AddHandler(handlers.NewCallbackQuery(filters.CallbackQuery.Prefix("slug:"), slugCallback))
+}
+
+// slugButton is added to the reply of sendLink, when short links can be
+// created.
+func slugButton(messageID int) *tg.KeyboardButtonCallback {
+	if cache.GetSlugs() == nil {
+		return nil
+	}
+	return &tg.KeyboardButtonCallback{
+		Text: "🔗 Short link",
+		Data: []byte(utils.SignCallback(fmt.Sprintf("slug:%d", messageID))),
+	}
+}
+
+// createSlug creates a short link for a new link to a message and returns
+// the reply to send.
+func createSlug(ctx *ext.Context, messageID int, slug string, createdBy int64) string {
+	file, err := utils.FileFromContext(ctx, messageID)
+	if err != nil {
+		return fmt.Sprintf("Error - %s", err.Error())
+	}
+	hash := utils.SignLink(messageID, file, utils.DefaultLinkClaims())
+	return newSlugReply(messageID, hash, slug, createdBy)
+}
+
+func newSlugReply(messageID int, hash string, slug string, createdBy int64) string {
+	record, err := utils.NewSlug(messageID, hash, slug, createdBy)
+	switch {
+	case errors.Is(err, cache.ErrSlugTaken):
+		return "❌ This short link is already taken, try another one."
+	case err != nil:
+		return fmt.Sprintf("Error - %s", err.Error())
+	}
+	return fmt.Sprintf("🔗 Short link:\n%s", utils.SlugURL(record.Slug))
+}
+
+// slugCallback handles slug:<messageID> by asking for a custom slug, and
+// slug:<messageID>:random by picking a random one.
+func slugCallback(ctx *ext.Context, u *ext.Update) error {
+	query := u.CallbackQuery
+	data, ok := utils.VerifyCallback(string(query.Data))
+	if !ok {
+		answerCallback(ctx, query, "This button is no longer valid.")
+		return dispatcher.EndGroups
+	}
+	parts := strings.Split(data, ":")
+	messageID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		answerCallback(ctx, query, "This button is no longer valid.")
+		return dispatcher.EndGroups
+	}
+	if !isOwner(query.UserID, messageID) {
+		answerCallback(ctx, query, slugOwnerOnly)
+		return dispatcher.EndGroups
+	}
+
+	answerCallback(ctx, query, "")
+	if len(parts) == 3 && parts[2] == "random" {
+		ctx.SendMessage(query.UserID, &tg.MessagesSendMessageRequest{
+			Message: createSlug(ctx, messageID, "", query.UserID),
+		})
+		return dispatcher.EndGroups
+	}
+
+	setPendingInput(query.UserID, pendingSlug, messageID)
+	ctx.SendMessage(query.UserID, &tg.MessagesSendMessageRequest{
+		Message: "Send the name of the short link, 3 to 32 letters, digits, - or _. Send /cancel to stop.",
+		ReplyMarkup: &tg.ReplyInlineMarkup{Rows: []tg.KeyboardButtonRow{{
+			Buttons: []tg.KeyboardButtonClass{&tg.KeyboardButtonCallback{
+				Text: "🎲 Random",
+				Data: []byte(utils.SignCallback(fmt.Sprintf("slug:%d:random", messageID))),
+			}},
+		}}},
+	})
+	return dispatcher.EndGroups
+}
+
+// setSlugInput creates the short link named after slugCallback asked for a
+// name.
+func setSlugInput(ctx *ext.Context, u *ext.Update, messageID int, slug string) error {
+	chatId := u.EffectiveChat().GetID()
+	if !isOwner(chatId, messageID) {
+		ctx.Reply(u, slugOwnerOnly, nil)
+		return dispatcher.EndGroups
+	}
+	if _, err := utils.NormalizeSlug(slug); err != nil {
+		setPendingInput(chatId, pendingSlug, messageID)
+		ctx.Reply(u, fmt.Sprintf("❌ %s, try again or send /cancel.", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, createSlug(ctx, messageID, slug, chatId), nil)
+	return dispatcher.EndGroups
+}
+
+// slugCommand names an existing link, or a new one for a bare message ID.
+// Either way only owners can create short links for a file.
+func slugCommand(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)[1:]
+	if len(args) == 0 || len(args) > 2 {
+		ctx.Reply(u, slugUsage, nil)
+		return dispatcher.EndGroups
+	}
+	slug := ""
+	if len(args) == 2 {
+		slug = args[1]
+	}
+	messageID, hash, err := utils.ParseLinkTarget(args[0])
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	if messageID == 0 {
+		ctx.Reply(u, "Send the whole link, a hash alone doesn't tell which file it is for.", nil)
+		return dispatcher.EndGroups
+	}
+	if !isOwner(chatId, messageID) {
+		ctx.Reply(u, slugOwnerOnly, nil)
+		return dispatcher.EndGroups
+	}
+	if hash == "" {
+		ctx.Reply(u, createSlug(ctx, messageID, slug, chatId), nil)
+		return dispatcher.EndGroups
+	}
+	file, err := utils.FileFromContext(ctx, messageID)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	if _, err := utils.VerifyLink(hash, messageID, file); err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, newSlugReply(messageID, hash, slug, chatId), nil)
+	return dispatcher.EndGroups
+}
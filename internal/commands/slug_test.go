@@ -0,0 +1,33 @@
+package commands
+
+import (
+	"EverythingSuckz/fsb/config"
+	"strings"
+	"testing"
+)
+
+func TestNewSlugReply(t *testing.T) {
+	old := *config.ValueOf
+	t.Cleanup(func() { *config.ValueOf = old })
+	config.ValueOf.Host = "https://example.com"
+
+	tests := []struct {
+		name  string
+		slug  string
+		reply string
+	}{
+		{name: "custom", slug: "My-Video", reply: "https://example.com/s/my-video"},
+		{name: "random", reply: "https://example.com/s/"},
+		{name: "taken", slug: "taken", reply: "already taken"},
+		{name: "invalid", slug: "a b", reply: "Error - "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withStores(t)
+			newSlugReply(11, "other", "taken", 2)
+			if reply := newSlugReply(10, "abc", tt.slug, 1); !strings.Contains(reply, tt.reply) {
+				t.Fatalf("reply %q doesn't contain %q", reply, tt.reply)
+			}
+		})
+	}
+}
@@ -77,6 +77,11 @@ func formatStatisticsMessage(stats types.StatisticsResponse) string {
 		stats.Total.FileCount, 
 		utils.FormatFileSizeShort(stats.Total.TotalSize))
 	
+	// Hotlink protection
+	message += fmt.Sprintf("🛡 Hotlinks blocked: %d today - %d all time\n\n",
+		stats.Today.HotlinksBlocked,
+		stats.Total.HotlinksBlocked)
+	
 	message += "🔄 Stats are updated in real-time\n"
 	message += "⏰ Last updated: " + time.Now().Format("2006-01-02 15:04:05") + "."
 	
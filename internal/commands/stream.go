@@ -2,7 +2,6 @@ package commands
 
 import (
 	"fmt"
-	"net/url"
 	"strings"
 
 	"EverythingSuckz/fsb/config"
@@ -118,18 +117,27 @@ func sendLink(ctx *ext.Context, u *ext.Update) error {
 		return dispatcher.EndGroups
 	}
 
-	update, err := utils.ForwardMessages(ctx, chatId, config.ValueOf.LogChannelID, u.EffectiveMessage.ID)
-	if err != nil {
-		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
-		return dispatcher.EndGroups
-	}
+	// the same document sent again reuses the log channel message it got
+	messageID, file, found := findUpload(ctx, u.EffectiveMessage.Media, chatId)
+	if !found {
+		var logMessage *tg.Message
+		if config.ValueOf.UploadMode == "copy" {
+			logMessage, err = utils.CopyMessage(ctx, u.EffectiveMessage.Message, chatId)
+		} else {
+			logMessage, err = utils.ForwardMessages(ctx, chatId, config.ValueOf.LogChannelID, u.EffectiveMessage.ID)
+		}
+		if err != nil {
+			ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+			return dispatcher.EndGroups
+		}
 
-	messageID := update.Updates[0].(*tg.UpdateMessageID).ID
-	doc := update.Updates[1].(*tg.UpdateNewChannelMessage).Message.(*tg.Message).Media
-	file, err := utils.FileFromMedia(doc)
-	if err != nil {
-		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
-		return dispatcher.EndGroups
+		messageID = logMessage.ID
+		file, err = utils.FileFromMedia(logMessage.Media)
+		if err != nil {
+			ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+			return dispatcher.EndGroups
+		}
+		utils.IndexFile(messageID, chatId, logMessage.Date, file, false)
 	}
 
 	if file.FileName == "" {
@@ -171,46 +179,12 @@ func sendLink(ctx *ext.Context, u *ext.Update) error {
 		}
 	}
 
-	emoji := fileTypeEmoji(file.MimeType)
-	size := formatFileSize(file.FileSize)
-	message := fmt.Sprintf(
-		"%s File Name: %s\n\n%s File Type: %s\n\n💾 Size: %s\n\n⏳ @yoelbots",
-		emoji, file.FileName,
-		emoji, file.MimeType,
-		size,
-	)
-
-	fullHash := utils.PackFile(file.FileName, file.FileSize, file.MimeType, file.ID)
-	hash := utils.GetShortHash(fullHash)
-
 	statsCache := cache.GetStatsCache()
 	if statsCache != nil {
 		_ = statsCache.RecordFileProcessed(file.FileSize)
 	}
 
-	videoParam := fmt.Sprintf("%d?hash=%s", messageID, hash)
-	encodedVideoParam := url.QueryEscape(videoParam)
-	encodedFilename := url.QueryEscape(file.FileName)
-	streamURL := fmt.Sprintf("https://file.streamgramm.workers.dev/?video=%s&filename=%s", encodedVideoParam, encodedFilename)
-
-	// --- Botones añadidos debajo del canal ---
-	row1 := tg.KeyboardButtonRow{
-		Buttons: []tg.KeyboardButtonClass{
-			&tg.KeyboardButtonURL{Text: "📢 @yoelbots", URL: "https://t.me/yoelbots"},
-		},
-	}
-	row2 := tg.KeyboardButtonRow{
-		Buttons: []tg.KeyboardButtonClass{
-			&tg.KeyboardButtonURL{Text: "🎬 Películas y Series en Español", URL: "https://t.me/peligxg"},
-		},
-	}
-	row3 := tg.KeyboardButtonRow{
-		Buttons: []tg.KeyboardButtonClass{
-			&tg.KeyboardButtonURL{Text: "Streaming / Download", URL: streamURL},
-		},
-	}
-
-	markup := &tg.ReplyInlineMarkup{Rows: []tg.KeyboardButtonRow{row1, row2, row3}}
+	message, markup := fileReply(messageID, file, isOwner(chatId, messageID))
 
 	_, err = ctx.Reply(u, message, &ext.ReplyOpts{
 		Markup:           markup,
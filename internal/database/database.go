@@ -45,7 +45,7 @@ func InitDatabase(log *zap.Logger) error {
 	}
 
 	// Auto migrate tables
-	err = db.AutoMigrate(&types.Stats{})
+	err = db.AutoMigrate(&types.Stats{}, &types.Revocation{}, &types.Link{}, &types.LinkPassword{}, &types.LinkBinding{}, &types.Slug{}, &types.FileRecord{})
 	if err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
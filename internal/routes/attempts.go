@@ -0,0 +1,125 @@
+package routes
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// freePasswordAttempts is how many wrong passwords an IP may send before
+	// it has to wait, and freeMessageAttempts how many a protected file may
+	// get from every IP together before checking its password slows down.
+	freePasswordAttempts = 5
+	freeMessageAttempts  = 50
+	// passwordBackoff is the first wait after the free attempts, doubling
+	// with every wrong password up to maxPasswordBackoff.
+	passwordBackoff    = time.Second
+	maxPasswordBackoff = 15 * time.Minute
+	// messageSlowdown is how much longer checking a password of a file takes
+	// after its free attempts, doubling up to maxMessageSlowdown. Unlike the
+	// wait of an IP it never turns anyone away, so guessing from many IPs
+	// can't lock the uploader out of their own file.
+	messageSlowdown    = 100 * time.Millisecond
+	maxMessageSlowdown = 3 * time.Second
+)
+
+// failedAttempts counts the wrong passwords of a key, like an IP or a file.
+type failedAttempts struct {
+	count int
+	until time.Time // no more attempts before this time, only set for IPs
+	last  time.Time
+}
+
+// passwordAttempts slows down guessing the passwords of protected files, by
+// making IPs wait and checking the passwords of files slower after every
+// wrong password.
+type passwordAttempts struct {
+	mu        sync.Mutex
+	failures  map[string]*failedAttempts
+	lastSweep time.Time
+}
+
+var unlockAttempts = &passwordAttempts{failures: make(map[string]*failedAttempts)}
+
+func attemptKeys(ip string, messageID int) (ipKey string, messageKey string) {
+	return "ip:" + ip, "message:" + strconv.Itoa(messageID)
+}
+
+// wait returns how long the IP has to wait before it may try a password
+// again, 0 when it may right away.
+func (a *passwordAttempts) wait(ip string, now time.Time) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ipKey, _ := attemptKeys(ip, 0)
+	if failure, ok := a.failures[ipKey]; ok && failure.until.After(now) {
+		return failure.until.Sub(now)
+	}
+	return 0
+}
+
+// slowdown returns how long checking a password of the file is held back,
+// for every IP alike.
+func (a *passwordAttempts) slowdown(messageID int) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, messageKey := attemptKeys("", messageID)
+	failure, ok := a.failures[messageKey]
+	if !ok || failure.count <= freeMessageAttempts {
+		return 0
+	}
+	return backoff(failure.count-freeMessageAttempts, messageSlowdown, maxMessageSlowdown)
+}
+
+// backoff doubles first for every attempt over the free ones, up to limit.
+func backoff(over int, first time.Duration, limit time.Duration) time.Duration {
+	if over > 20 {
+		return limit
+	}
+	return min(first<<(over-1), limit)
+}
+
+// fail records a wrong password of the IP for the file.
+func (a *passwordAttempts) fail(ip string, messageID int, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sweep(now)
+	ipKey, messageKey := attemptKeys(ip, messageID)
+	if failure := a.add(ipKey, now); failure.count > freePasswordAttempts {
+		failure.until = now.Add(backoff(failure.count-freePasswordAttempts, passwordBackoff, maxPasswordBackoff))
+	}
+	a.add(messageKey, now)
+}
+
+func (a *passwordAttempts) add(key string, now time.Time) *failedAttempts {
+	failure, ok := a.failures[key]
+	if !ok {
+		failure = &failedAttempts{}
+		a.failures[key] = failure
+	}
+	failure.count++
+	failure.last = now
+	return failure
+}
+
+// succeed forgets the wrong passwords of the IP once it sent the right one.
+func (a *passwordAttempts) succeed(ip string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ipKey, _ := attemptKeys(ip, 0)
+	delete(a.failures, ipKey)
+}
+
+// sweep drops the keys that have not sent a wrong password for longer than
+// the longest wait, at most once a minute.
+func (a *passwordAttempts) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < time.Minute {
+		return
+	}
+	a.lastSweep = now
+	for key, failure := range a.failures {
+		if now.Sub(failure.last) > 2*maxPasswordBackoff {
+			delete(a.failures, key)
+		}
+	}
+}
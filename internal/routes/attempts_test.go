@@ -0,0 +1,83 @@
+package routes
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPasswordAttempts(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name     string
+		failures int
+		ip       string
+		after    time.Duration
+		want     time.Duration
+	}{
+		{name: "free attempts", failures: freePasswordAttempts, ip: "1.1.1.1"},
+		{name: "first backoff", failures: freePasswordAttempts + 1, ip: "1.1.1.1", want: passwordBackoff},
+		{name: "doubling", failures: freePasswordAttempts + 3, ip: "1.1.1.1", want: 4 * passwordBackoff},
+		{name: "capped", failures: freePasswordAttempts + 100, ip: "1.1.1.1", want: maxPasswordBackoff},
+		{name: "waited", failures: freePasswordAttempts + 3, ip: "1.1.1.1", after: 4 * passwordBackoff},
+		{name: "other IP", failures: freePasswordAttempts + 3, ip: "2.2.2.2"},
+		// guessing from other IPs must not lock anyone out of a file
+		{name: "other IP, many failures", failures: freeMessageAttempts + 100, ip: "2.2.2.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := &passwordAttempts{failures: make(map[string]*failedAttempts)}
+			for i := 0; i < tt.failures; i++ {
+				attempts.fail("1.1.1.1", 1, now)
+			}
+			if got := attempts.wait(tt.ip, now.Add(tt.after)); got != tt.want {
+				t.Fatalf("wait = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordSlowdown(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name     string
+		failures int
+		message  int
+		want     time.Duration
+	}{
+		{name: "free attempts", failures: freeMessageAttempts, message: 1},
+		{name: "first slowdown", failures: freeMessageAttempts + 1, message: 1, want: messageSlowdown},
+		{name: "doubling", failures: freeMessageAttempts + 3, message: 1, want: 4 * messageSlowdown},
+		{name: "capped", failures: freeMessageAttempts + 100, message: 1, want: maxMessageSlowdown},
+		{name: "other file", failures: freeMessageAttempts + 100, message: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := &passwordAttempts{failures: make(map[string]*failedAttempts)}
+			// spread over many IPs, so none of them has to wait
+			for i := 0; i < tt.failures; i++ {
+				attempts.fail(fmt.Sprintf("10.0.%d.%d", i/256, i%256), 1, now)
+			}
+			if got := attempts.slowdown(tt.message); got != tt.want {
+				t.Fatalf("slowdown = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordAttemptsSucceed(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	attempts := &passwordAttempts{failures: make(map[string]*failedAttempts)}
+	for i := 0; i <= freePasswordAttempts; i++ {
+		attempts.fail("1.1.1.1", 1, now)
+	}
+	attempts.succeed("1.1.1.1")
+	if got := attempts.wait("1.1.1.1", now); got != 0 {
+		t.Fatalf("wait after the right password = %v, want 0", got)
+	}
+
+	attempts.fail("2.2.2.2", 2, now.Add(3*maxPasswordBackoff))
+	if _, ok := attempts.failures["message:1"]; ok {
+		t.Fatal("sweep kept an old file")
+	}
+}
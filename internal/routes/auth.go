@@ -0,0 +1,55 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authorize checks the hash of a link against the file it points at. When
+// the link doesn't grant access the request is answered and false is
+// returned.
+func authorize(ctx *gin.Context, messageID int, hash string, file *types.File) (*utils.LinkClaims, bool) {
+	claims, err := utils.VerifyLink(hash, messageID, file)
+	if err != nil {
+		http.Error(ctx.Writer, err.Error(), linkErrorStatus(err))
+		return nil, false
+	}
+	return claims, true
+}
+
+// linkErrorStatus maps an error of utils.VerifyLink to a response status.
+func linkErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, utils.ErrLinkRevoked):
+		return http.StatusForbidden
+	case errors.Is(err, utils.ErrLinkExpired), errors.Is(err, utils.ErrLinkUsedUp):
+		return http.StatusGone
+	}
+	return http.StatusBadRequest
+}
+
+// requireAPIKey guards the admin API. The key is sent either in the
+// X-API-Key header or as a bearer token, and the API is disabled as long as
+// API_KEY is not set.
+func requireAPIKey(ctx *gin.Context) {
+	if config.ValueOf.APIKey == "" {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "API is disabled"})
+		return
+	}
+	key := ctx.GetHeader("X-API-Key")
+	if key == "" {
+		key = strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(config.ValueOf.APIKey)) != 1 {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid API key"})
+		return
+	}
+	ctx.Next()
+}
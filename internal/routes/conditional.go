@@ -0,0 +1,95 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/types"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fileETag builds a strong validator for a file. Telegram documents never
+// change their content, so the document ID and size identify the bytes.
+func fileETag(file *types.File) string {
+	if thumbType := file.ThumbType(); thumbType != "" {
+		return fmt.Sprintf(`"%x-%s-%x"`, file.ID, thumbType, file.FileSize)
+	}
+	return fmt.Sprintf(`"%x-%x"`, file.ID, file.FileSize)
+}
+
+func fileLastModified(file *types.File) time.Time {
+	return time.Unix(int64(file.Date), 0).UTC()
+}
+
+// setValidators writes the ETag and Last-Modified headers of a file.
+func setValidators(ctx *gin.Context, file *types.File) {
+	ctx.Header("ETag", fileETag(file))
+	if file.Date != 0 {
+		ctx.Header("Last-Modified", fileLastModified(file).Format(http.TimeFormat))
+	}
+}
+
+// checkNotModified evaluates If-None-Match and If-Modified-Since and writes a
+// 304 response when the client already has the file. It returns true when
+// the request has been answered.
+func checkNotModified(ctx *gin.Context, file *types.File) bool {
+	r := ctx.Request
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	notModified := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		notModified = etagListMatch(inm, fileETag(file), false)
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && file.Date != 0 {
+		t, err := http.ParseTime(ims)
+		notModified = err == nil && !fileLastModified(file).After(t)
+	}
+	if !notModified {
+		return false
+	}
+	h := ctx.Writer.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Disposition")
+	ctx.Status(http.StatusNotModified)
+	ctx.Writer.WriteHeaderNow()
+	return true
+}
+
+// rangeAllowed reports whether the Range header should be honoured, which is
+// the case unless an If-Range validator no longer matches the file.
+func rangeAllowed(r *http.Request, file *types.File) bool {
+	ir := strings.TrimSpace(r.Header.Get("If-Range"))
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return etagListMatch(ir, fileETag(file), true)
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && file.Date != 0 && fileLastModified(file).Equal(t)
+}
+
+// etagListMatch checks a comma separated list of entity tags against etag.
+// Strong comparison never matches weak tags, weak comparison ignores the W/
+// prefix on both sides.
+func etagListMatch(list string, etag string, strong bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" && !strong {
+			return true
+		}
+		if weak := strings.HasPrefix(candidate, "W/"); weak {
+			if strong {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
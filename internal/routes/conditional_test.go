@@ -0,0 +1,118 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gotd/td/tg"
+)
+
+func testConditionalFile() *types.File {
+	return &types.File{
+		Location: &tg.InputDocumentFileLocation{ID: 0xabc},
+		FileSize: 0x400,
+		ID:       0xabc,
+		Date:     1700000000,
+	}
+}
+
+func TestFileETag(t *testing.T) {
+	file := testConditionalFile()
+	if etag := fileETag(file); etag != `"abc-400"` {
+		t.Fatalf("fileETag = %s", etag)
+	}
+	thumb := *file
+	thumb.Location = &tg.InputDocumentFileLocation{ID: 0xabc, ThumbSize: "m"}
+	if etag := fileETag(&thumb); etag == fileETag(file) {
+		t.Fatal("a thumbnail has the ETag of its file")
+	}
+}
+
+func TestCheckNotModified(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	file := testConditionalFile()
+	etag := fileETag(file)
+	modified := fileLastModified(file)
+
+	tests := []struct {
+		name        string
+		method      string
+		header      http.Header
+		notModified bool
+	}{
+		{name: "no validators", method: http.MethodGet},
+		{name: "matching ETag", method: http.MethodGet, header: http.Header{"If-None-Match": {etag}}, notModified: true},
+		{name: "matching weak ETag", method: http.MethodGet, header: http.Header{"If-None-Match": {"W/" + etag}}, notModified: true},
+		{name: "ETag in a list", method: http.MethodGet, header: http.Header{"If-None-Match": {`"other", ` + etag}}, notModified: true},
+		{name: "any ETag", method: http.MethodHead, header: http.Header{"If-None-Match": {"*"}}, notModified: true},
+		{name: "other ETag", method: http.MethodGet, header: http.Header{"If-None-Match": {`"other"`}}},
+		{name: "not modified since", method: http.MethodGet, header: http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}}, notModified: true},
+		{name: "modified since", method: http.MethodGet, header: http.Header{"If-Modified-Since": {modified.Add(-time.Hour).Format(http.TimeFormat)}}},
+		{name: "invalid date", method: http.MethodGet, header: http.Header{"If-Modified-Since": {"yesterday"}}},
+		// If-None-Match takes precedence over If-Modified-Since
+		{
+			name:   "other ETag, not modified since",
+			method: http.MethodGet,
+			header: http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {modified.Format(http.TimeFormat)}},
+		},
+		{name: "not a GET", method: http.MethodPost, header: http.Header{"If-None-Match": {etag}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(w)
+			ctx.Request = httptest.NewRequest(tt.method, "/stream/1", nil)
+			for key, values := range tt.header {
+				ctx.Request.Header[key] = values
+			}
+			ctx.Header("Content-Type", "video/mp4")
+			if got := checkNotModified(ctx, file); got != tt.notModified {
+				t.Fatalf("checkNotModified = %v, want %v", got, tt.notModified)
+			}
+			if !tt.notModified {
+				return
+			}
+			if w.Code != http.StatusNotModified {
+				t.Fatalf("status = %d, want 304", w.Code)
+			}
+			if w.Header().Get("Content-Type") != "" {
+				t.Fatal("a 304 response kept its Content-Type")
+			}
+		})
+	}
+}
+
+func TestRangeAllowed(t *testing.T) {
+	file := testConditionalFile()
+	etag := fileETag(file)
+	modified := fileLastModified(file)
+
+	tests := []struct {
+		name    string
+		ifRange string
+		allowed bool
+	}{
+		{name: "no If-Range", allowed: true},
+		{name: "matching ETag", ifRange: etag, allowed: true},
+		{name: "weak ETag", ifRange: "W/" + etag},
+		{name: "other ETag", ifRange: `"other"`},
+		{name: "matching date", ifRange: modified.Format(http.TimeFormat), allowed: true},
+		{name: "other date", ifRange: modified.Add(time.Second).Format(http.TimeFormat)},
+		{name: "invalid", ifRange: "yesterday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/stream/1", nil)
+			if tt.ifRange != "" {
+				r.Header.Set("If-Range", tt.ifRange)
+			}
+			if got := rangeAllowed(r, file); got != tt.allowed {
+				t.Fatalf("rangeAllowed = %v, want %v", got, tt.allowed)
+			}
+		})
+	}
+}
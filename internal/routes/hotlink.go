@@ -0,0 +1,122 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/utils"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// checkHotlink refuses requests for a link embedded on a site that isn't
+// allowed, or coming from another client than the one the link is bound to.
+// It returns false when the request has been answered.
+func checkHotlink(ctx *gin.Context, kind utils.LinkKind, messageID int, hash string) bool {
+	return checkReferer(ctx, kind) && checkBinding(ctx, messageID, hash)
+}
+
+// checkReferer compares the Origin or Referer of a request with
+// ALLOWED_REFERERS. Requests without either are allowed for the link kinds
+// in EMPTY_REFERER_KINDS, as media players and direct downloads send none.
+func checkReferer(ctx *gin.Context, kind utils.LinkKind) bool {
+	if len(config.ValueOf.AllowedReferers) == 0 {
+		return true
+	}
+	source := ctx.GetHeader("Origin")
+	if source == "" || source == "null" {
+		source = ctx.GetHeader("Referer")
+	}
+	if source == "" {
+		if slices.Contains(config.ValueOf.EmptyRefererKinds, string(kind)) {
+			return true
+		}
+		blockHotlink(ctx, "missing referer", zap.String("kind", string(kind)))
+		return false
+	}
+	if !refererAllowed(source) {
+		blockHotlink(ctx, "referer not allowed", zap.String("referer", source))
+		return false
+	}
+	return true
+}
+
+// refererAllowed reports whether source is this server or matches one of
+// ALLOWED_REFERERS, where *.example.com matches every subdomain.
+func refererAllowed(source string) bool {
+	u, err := url.Parse(source)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if own, err := url.Parse(config.ValueOf.Host); err == nil && strings.EqualFold(own.Hostname(), host) {
+		return true
+	}
+	for _, allowed := range config.ValueOf.AllowedReferers {
+		if allowed == host || strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBinding binds a signed link to the address, or subnet, of the first
+// client using it, depending on LINK_IP_BINDING.
+func checkBinding(ctx *gin.Context, messageID int, hash string) bool {
+	mode := config.ValueOf.LinkIPBinding
+	if mode == "off" || !utils.IsSignedHash(hash) {
+		return true
+	}
+	bindings := cache.GetBindings()
+	if bindings == nil {
+		return true
+	}
+	addr, err := netip.ParseAddr(ctx.ClientIP())
+	if err != nil {
+		blockHotlink(ctx, "unknown client address", zap.String("ip", ctx.ClientIP()))
+		return false
+	}
+	addr = addr.Unmap()
+	bits := addr.BitLen()
+	if mode == "subnet" {
+		bits = 64
+		if addr.Is4() {
+			bits = 24
+		}
+	}
+	network, err := addr.Prefix(bits)
+	if err != nil {
+		http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	ok, err := bindings.Bind(hash, messageID, network, addr)
+	if err != nil {
+		log.Error("Failed to bind link", zap.Int("messageID", messageID), zap.Error(err))
+		http.Error(ctx.Writer, "failed to check link binding", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		blockHotlink(ctx, "link bound to another client", zap.Int("messageID", messageID))
+		return false
+	}
+	return true
+}
+
+// blockHotlink logs and counts a refused request, and answers it.
+func blockHotlink(ctx *gin.Context, reason string, fields ...zap.Field) {
+	fields = append([]zap.Field{
+		zap.String("reason", reason),
+		zap.String("path", ctx.Request.URL.Path),
+		zap.String("ip", ctx.ClientIP()),
+	}, fields...)
+	log.Warn("Hotlink blocked", fields...)
+	if statsCache := cache.GetStatsCache(); statsCache != nil {
+		statsCache.RecordHotlinkBlocked()
+	}
+	http.Error(ctx.Writer, reason, http.StatusForbidden)
+}
@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/utils"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type createLinkRequest struct {
+	MessageID    int   `json:"message_id" binding:"required"`
+	MaxDownloads int64 `json:"max_downloads"`
+	MaxBytes     int64 `json:"max_bytes"`
+	ExpiresIn    int64 `json:"expires_in"` // seconds, overrides LINK_EXPIRY
+	DownloadOnly bool  `json:"download_only"`
+}
+
+func (e *allRoutes) LoadLinksAPI(r *Route) {
+	api := r.Engine.Group("/api/links", requireAPIKey)
+	api.POST("", e.createLink)
+	api.GET("/:id", e.getLink)
+}
+
+func (e *allRoutes) createLink(c *gin.Context) {
+	var req createLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	file, err := utils.FileFromMessage(c, bot.GetNextWorker().Client, req.MessageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	claims, link, err := utils.NewLimitedLink(req.MessageID, 0, req.MaxDownloads, req.MaxBytes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ExpiresIn > 0 {
+		claims.Expiry = time.Now().Add(time.Duration(req.ExpiresIn) * time.Second).Unix()
+	}
+	if req.DownloadOnly {
+		claims.Flags |= utils.FlagDownloadOnly
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"link":  link,
+			"hash":  utils.SignLink(req.MessageID, file, claims),
+			"links": utils.FileLinks(req.MessageID, file, claims),
+		},
+	})
+}
+
+func (e *allRoutes) getLink(c *gin.Context) {
+	links := cache.GetLinks()
+	if links == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Link service is not available"})
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	link, err := links.Get(uint(id))
+	if errors.Is(err, cache.ErrLinkNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		e.log.Error("Failed to get link", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get link"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    link,
+	})
+}
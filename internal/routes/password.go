@@ -0,0 +1,185 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/utils"
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// unlockCookieTTL is how long a correct password is remembered by the
+// browser that entered it.
+const unlockCookieTTL = time.Hour
+
+var passwordTemplate = template.Must(template.ParseFS(templateFS, "templates/password.html"))
+
+type passwordPage struct {
+	Action string
+	Next   string
+	Wrong  bool
+}
+
+func (e *allRoutes) LoadUnlock(r *Route) {
+	defer e.log.Named("Unlock").Info("Loaded unlock route")
+	r.Engine.POST("/unlock/:messageID", e.postUnlockRoute)
+}
+
+func unlockCookieName(messageID int) string {
+	return fmt.Sprintf("fsb_unlock_%d", messageID)
+}
+
+func setUnlockCookie(ctx *gin.Context, messageID int) {
+	value := utils.SignUnlock(messageID, time.Now().Add(unlockCookieTTL))
+	secure := strings.HasPrefix(config.ValueOf.Host, "https://")
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(unlockCookieName(messageID), value, int(unlockCookieTTL.Seconds()), "/", "", secure, true)
+}
+
+// unlocked reports whether a request may access the links of a message,
+// which needs either the cookie of an earlier password check or the password
+// in Basic auth when the message is protected. retryAfter is set when the
+// client sent too many wrong passwords to check another one now.
+func unlocked(ctx *gin.Context, messageID int) (ok bool, retryAfter time.Duration) {
+	passwords := cache.GetPasswords()
+	if passwords == nil || !passwords.Protected(messageID) {
+		return true, 0
+	}
+	if cookie, err := ctx.Cookie(unlockCookieName(messageID)); err == nil && utils.VerifyUnlock(cookie, messageID) {
+		return true, 0
+	}
+	_, password, ok := ctx.Request.BasicAuth()
+	if !ok {
+		return false, 0
+	}
+	if ok, retryAfter = checkPassword(ctx, passwords, messageID, password); !ok {
+		return false, retryAfter
+	}
+	setUnlockCookie(ctx, messageID)
+	return true, 0
+}
+
+// checkPassword checks a password for a message. When the client sent too
+// many wrong passwords, it isn't checked and retryAfter is how long the
+// client has to wait. Files that got many wrong passwords are checked
+// slower, without turning anyone away.
+func checkPassword(ctx *gin.Context, passwords *cache.PasswordStore, messageID int, password string) (ok bool, retryAfter time.Duration) {
+	ip := ctx.ClientIP()
+	if wait := unlockAttempts.wait(ip, time.Now()); wait > 0 {
+		log.Debug("Password attempts throttled", zap.String("ip", ip), zap.Int("messageID", messageID), zap.Duration("wait", wait))
+		return false, wait
+	}
+	if slowdown := unlockAttempts.slowdown(messageID); slowdown > 0 {
+		select {
+		case <-time.After(slowdown):
+		case <-ctx.Request.Context().Done():
+			return false, 0
+		}
+	}
+	if !passwords.Check(messageID, password) {
+		unlockAttempts.fail(ip, messageID, time.Now())
+		return false, 0
+	}
+	unlockAttempts.succeed(ip)
+	return true, 0
+}
+
+func tooManyAttempts(ctx *gin.Context, retryAfter time.Duration) {
+	ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(ctx.Writer, "too many wrong passwords, try again later", http.StatusTooManyRequests)
+}
+
+// requirePassword answers requests for protected messages that are not
+// unlocked yet. Browsers get the password form when form is set, everything
+// else, like media players, a Basic auth challenge. It returns false when the
+// request has been answered.
+func requirePassword(ctx *gin.Context, messageID int, hash string, form bool) bool {
+	ok, retryAfter := unlocked(ctx, messageID)
+	if ok {
+		return true
+	}
+	if retryAfter > 0 {
+		tooManyAttempts(ctx, retryAfter)
+		return false
+	}
+	if form && ctx.Request.Method == http.MethodGet && strings.Contains(ctx.GetHeader("Accept"), "text/html") {
+		renderPasswordPage(ctx, messageID, hash, ctx.Request.URL.RequestURI(), false)
+		return false
+	}
+	ctx.Header("WWW-Authenticate", `Basic realm="Protected file", charset="UTF-8"`)
+	http.Error(ctx.Writer, "password required", http.StatusUnauthorized)
+	return false
+}
+
+// renderPasswordPage shows the password form. It is sent without a Basic
+// auth challenge, as browsers would show their own prompt instead.
+func renderPasswordPage(ctx *gin.Context, messageID int, hash string, next string, wrong bool) {
+	page := passwordPage{
+		Action: fmt.Sprintf("/unlock/%d?%s", messageID, url.Values{"hash": {hash}}.Encode()),
+		Next:   next,
+		Wrong:  wrong,
+	}
+	var body bytes.Buffer
+	if err := passwordTemplate.Execute(&body, page); err != nil {
+		log.Error("Failed to render password page", zap.Error(err))
+		http.Error(ctx.Writer, "failed to render page", http.StatusInternalServerError)
+		return
+	}
+	ctx.Data(http.StatusUnauthorized, "text/html; charset=utf-8", body.Bytes())
+}
+
+// postUnlockRoute checks the password sent by the form and sends the browser
+// back to the page it came from.
+func (e *allRoutes) postUnlockRoute(ctx *gin.Context) {
+	w := ctx.Writer
+
+	messageID, err := strconv.Atoi(ctx.Param("messageID"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	worker := bot.GetNextWorker()
+
+	file, err := utils.FileFromMessage(ctx, worker.Client, messageID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the password of a file can only be tried with a valid link to it
+	hash := ctx.Query("hash")
+	if _, ok := authorize(ctx, messageID, hash, file); !ok {
+		return
+	}
+
+	next := ctx.PostForm("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = fmt.Sprintf("/watch/%d?%s", messageID, url.Values{"hash": {hash}}.Encode())
+	}
+
+	passwords := cache.GetPasswords()
+	if passwords != nil && passwords.Protected(messageID) {
+		if ok, retryAfter := checkPassword(ctx, passwords, messageID, ctx.PostForm("password")); !ok {
+			if retryAfter > 0 {
+				tooManyAttempts(ctx, retryAfter)
+				return
+			}
+			renderPasswordPage(ctx, messageID, hash, next, true)
+			return
+		}
+	}
+	setUnlockCookie(ctx, messageID)
+	ctx.Redirect(http.StatusSeeOther, next)
+}
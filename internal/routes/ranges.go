@@ -0,0 +1,188 @@
+package routes
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+
+	range_parser "github.com/quantumsheep/range-parser"
+)
+
+var (
+	errMalformedRange     = errors.New("malformed range header")
+	errUnsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+// maxRanges is the most ranges served in one multipart/byteranges response.
+// Requests for more get the whole file instead.
+const maxRanges = 16
+
+// parseRanges parses a Range header against a file of the given size.
+// Overlapping and adjacent ranges are merged, and invalid specs are skipped
+// as RFC 9110 asks. errMalformedRange means the header should be ignored,
+// errUnsatisfiableRange means none of the ranges overlap the file. No ranges
+// and no error means the whole file should be served, which is the case when
+// the ranges add up to more than the file or there are more than maxRanges.
+func parseRanges(size int64, header string) ([]*range_parser.Range, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok {
+		return nil, errMalformedRange
+	}
+	var ranges []*range_parser.Range
+	var requested int64
+	valid := false
+	for _, s := range strings.Split(spec, ",") {
+		ra, ok := parseRange(size, strings.TrimSpace(s))
+		if !ok {
+			continue
+		}
+		valid = true
+		if ra == nil {
+			continue
+		}
+		requested += ra.End - ra.Start + 1
+		if requested > size {
+			return nil, nil
+		}
+		ranges = append(ranges, ra)
+	}
+	if !valid {
+		return nil, errMalformedRange
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	ranges = mergeRanges(ranges)
+	if len(ranges) > maxRanges {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// parseRange parses a single range spec. ok is false for invalid specs, and
+// the range is nil for valid ones that don't overlap the file.
+func parseRange(size int64, spec string) (ra *range_parser.Range, ok bool) {
+	first, last, found := strings.Cut(spec, "-")
+	if !found || (first == "" && last == "") {
+		return nil, false
+	}
+	if first == "" {
+		suffix, err := parseRangeNumber(last)
+		if err != nil {
+			return nil, false
+		}
+		// suffixes longer than the file ask for all of it
+		if suffix == 0 || size == 0 {
+			return nil, true
+		}
+		return &range_parser.Range{Start: max(size-suffix, 0), End: size - 1}, true
+	}
+	start, err := parseRangeNumber(first)
+	if err != nil {
+		return nil, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = parseRangeNumber(last)
+		if err != nil || end < start {
+			return nil, false
+		}
+	}
+	if start >= size {
+		return nil, true
+	}
+	return &range_parser.Range{Start: start, End: min(end, size-1)}, true
+}
+
+func parseRangeNumber(s string) (int64, error) {
+	// ParseInt would also accept signs
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, errMalformedRange
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// mergeRanges sorts ranges by their start and joins the ones that overlap
+// or touch, so no byte is sent twice.
+func mergeRanges(ranges []*range_parser.Range) []*range_parser.Range {
+	slices.SortFunc(ranges, func(a, b *range_parser.Range) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	merged := []*range_parser.Range{ranges[0]}
+	for _, ra := range ranges[1:] {
+		last := merged[len(merged)-1]
+		if ra.Start <= last.End+1 {
+			last.End = max(last.End, ra.End)
+			continue
+		}
+		merged = append(merged, ra)
+	}
+	return merged
+}
+
+// multipartRanges writes a multipart/byteranges body, leaving the content of
+// every part to the caller.
+type multipartRanges struct {
+	writer   *multipart.Writer
+	mimeType string
+	size     int64
+}
+
+func newMultipartRanges(w io.Writer, boundary string, mimeType string, size int64) (*multipartRanges, error) {
+	mw := multipart.NewWriter(w)
+	if boundary != "" {
+		if err := mw.SetBoundary(boundary); err != nil {
+			return nil, err
+		}
+	}
+	return &multipartRanges{writer: mw, mimeType: mimeType, size: size}, nil
+}
+
+func (m *multipartRanges) ContentType() string {
+	return "multipart/byteranges; boundary=" + m.writer.Boundary()
+}
+
+func (m *multipartRanges) CreatePart(ra *range_parser.Range) (io.Writer, error) {
+	return m.writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":  {m.mimeType},
+		"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", ra.Start, ra.End, m.size)},
+	})
+}
+
+func (m *multipartRanges) Close() error {
+	return m.writer.Close()
+}
+
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// multipartLength returns the exact length of a multipart/byteranges body so
+// that Content-Length can be sent before streaming any part.
+func multipartLength(ranges []*range_parser.Range, boundary string, mimeType string, size int64) (int64, error) {
+	var w countingWriter
+	mr, err := newMultipartRanges(&w, boundary, mimeType, size)
+	if err != nil {
+		return 0, err
+	}
+	var length int64
+	for _, ra := range ranges {
+		if _, err := mr.CreatePart(ra); err != nil {
+			return 0, err
+		}
+		length += ra.End - ra.Start + 1
+	}
+	if err := mr.Close(); err != nil {
+		return 0, err
+	}
+	return length + int64(w), nil
+}
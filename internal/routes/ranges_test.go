@@ -0,0 +1,92 @@
+package routes
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	range_parser "github.com/quantumsheep/range-parser"
+)
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int64
+		header string
+		want   [][2]int64
+		err    error
+	}{
+		{name: "single", size: 1000, header: "bytes=0-499", want: [][2]int64{{0, 499}}},
+		{name: "open end", size: 1000, header: "bytes=500-", want: [][2]int64{{500, 999}}},
+		{name: "end past size", size: 1000, header: "bytes=900-2000", want: [][2]int64{{900, 999}}},
+		{name: "suffix", size: 1000, header: "bytes=-100", want: [][2]int64{{900, 999}}},
+		{name: "suffix longer than file", size: 1000, header: "bytes=-5000", want: [][2]int64{{0, 999}}},
+		{name: "spaces", size: 1000, header: " bytes=0-9 , 20-29", want: [][2]int64{{0, 9}, {20, 29}}},
+		{name: "several", size: 1000, header: "bytes=0-9,20-29,-10", want: [][2]int64{{0, 9}, {20, 29}, {990, 999}}},
+		{name: "sorted", size: 1000, header: "bytes=20-29,0-9", want: [][2]int64{{0, 9}, {20, 29}}},
+		{name: "overlapping merged", size: 1000, header: "bytes=0-99,50-149", want: [][2]int64{{0, 149}}},
+		{name: "adjacent merged", size: 1000, header: "bytes=0-9,10-19", want: [][2]int64{{0, 19}}},
+		{name: "contained merged", size: 1000, header: "bytes=0-99,10-19,200-209", want: [][2]int64{{0, 99}, {200, 209}}},
+		{name: "repeated whole file", size: 1000, header: "bytes=0-,0-,0-,0-"},
+		{name: "adds up to more than the file", size: 100, header: "bytes=0-59,40-99"},
+		{name: "too many ranges", size: 1000, header: "bytes=0-0,2-2,4-4,6-6,8-8,10-10,12-12,14-14,16-16,18-18,20-20,22-22,24-24,26-26,28-28,30-30,32-32"},
+		{name: "invalid spec skipped", size: 1000, header: "bytes=abc,0-9", want: [][2]int64{{0, 9}}},
+		{name: "reversed spec skipped", size: 1000, header: "bytes=9-0,20-29", want: [][2]int64{{20, 29}}},
+		{name: "signed spec skipped", size: 1000, header: "bytes=+5-9,20-29", want: [][2]int64{{20, 29}}},
+		{name: "unsatisfiable skipped", size: 1000, header: "bytes=5000-,0-9", want: [][2]int64{{0, 9}}},
+		{name: "other unit", size: 1000, header: "items=0-9", err: errMalformedRange},
+		{name: "only invalid", size: 1000, header: "bytes=abc,-", err: errMalformedRange},
+		{name: "empty", size: 1000, header: "bytes=", err: errMalformedRange},
+		{name: "past the end", size: 1000, header: "bytes=1000-", err: errUnsatisfiableRange},
+		{name: "zero suffix", size: 1000, header: "bytes=-0", err: errUnsatisfiableRange},
+		{name: "empty file", size: 0, header: "bytes=0-", err: errUnsatisfiableRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges, err := parseRanges(tt.size, tt.header)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseRanges(%d, %q) error = %v, want %v", tt.size, tt.header, err, tt.err)
+			}
+			got := make([][2]int64, 0, len(ranges))
+			for _, ra := range ranges {
+				got = append(got, [2]int64{ra.Start, ra.End})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseRanges(%d, %q) = %v, want %v", tt.size, tt.header, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("parseRanges(%d, %q) = %v, want %v", tt.size, tt.header, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMultipartLength(t *testing.T) {
+	const boundary = "3d6b6a416f9b5"
+	ranges := []*range_parser.Range{{Start: 0, End: 9}, {Start: 100, End: 199}, {Start: 990, End: 999}}
+	want, err := multipartLength(ranges, boundary, "video/mp4", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	mr, err := newMultipartRanges(&body, boundary, "video/mp4", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ra := range ranges {
+		part, err := mr.CreatePart(ra)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(bytes.Repeat([]byte{'x'}, int(ra.End-ra.Start+1)))
+	}
+	if err := mr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if int64(body.Len()) != want {
+		t.Fatalf("multipartLength = %d, body is %d bytes", want, body.Len())
+	}
+}
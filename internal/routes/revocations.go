@@ -0,0 +1,125 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type revocationRequest struct {
+	MessageID int    `json:"message_id"`
+	Token     string `json:"token"`
+	Link      string `json:"link"`
+	Reason    string `json:"reason"`
+}
+
+func (e *allRoutes) LoadRevocationsAPI(r *Route) {
+	api := r.Engine.Group("/api/revocations", requireAPIKey)
+	api.GET("", e.listRevocations)
+	api.POST("", e.revoke)
+	api.DELETE("", e.unrevoke)
+}
+
+// target resolves what a request revokes. A link takes precedence over the
+// message ID and token fields.
+func (req *revocationRequest) target() (int, string, error) {
+	if req.Link != "" {
+		return utils.ParseLinkTarget(req.Link)
+	}
+	if req.MessageID == 0 && req.Token == "" {
+		return 0, "", errors.New("one of message_id, token or link is required")
+	}
+	return req.MessageID, req.Token, nil
+}
+
+func parseRevocationRequest(c *gin.Context) (*revocationRequest, int, string, bool) {
+	var req revocationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, 0, "", false
+	}
+	messageID, token, err := req.target()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, 0, "", false
+	}
+	return &req, messageID, token, true
+}
+
+func revocationStore(c *gin.Context) *cache.RevocationStore {
+	revocations := cache.GetRevocations()
+	if revocations == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Revocation service is not available",
+		})
+	}
+	return revocations
+}
+
+func (e *allRoutes) listRevocations(c *gin.Context) {
+	revocations := revocationStore(c)
+	if revocations == nil {
+		return
+	}
+	list, err := revocations.List()
+	if err != nil {
+		e.log.Error("Failed to list revocations", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list revocations"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    list,
+	})
+}
+
+func (e *allRoutes) revoke(c *gin.Context) {
+	revocations := revocationStore(c)
+	if revocations == nil {
+		return
+	}
+	req, messageID, token, ok := parseRevocationRequest(c)
+	if !ok {
+		return
+	}
+	revocation := &types.Revocation{MessageID: messageID, Token: token, Reason: req.Reason}
+	err := revocations.Revoke(revocation)
+	if errors.Is(err, cache.ErrLegacyWithoutMessage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		e.log.Error("Failed to revoke", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (e *allRoutes) unrevoke(c *gin.Context) {
+	revocations := revocationStore(c)
+	if revocations == nil {
+		return
+	}
+	_, messageID, token, ok := parseRevocationRequest(c)
+	if !ok {
+		return
+	}
+	err := revocations.Unrevoke(messageID, token)
+	switch {
+	case errors.Is(err, cache.ErrNotRevoked):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, cache.ErrLegacyWithoutMessage):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		e.log.Error("Failed to unrevoke", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unrevoke"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	}
+}
@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (e *allRoutes) LoadSlug(r *Route) {
+	defer e.log.Named("Slug").Info("Loaded short link route")
+	r.Engine.GET("/s/:slug", getSlugRoute)
+}
+
+// getSlugRoute streams the file of a short link, checking the link it stands
+// for like /stream does.
+func getSlugRoute(ctx *gin.Context) {
+	w := ctx.Writer
+
+	slugs := cache.GetSlugs()
+	if slugs == nil {
+		http.Error(w, "short links are not available", http.StatusServiceUnavailable)
+		return
+	}
+	slug, err := slugs.Get(strings.ToLower(ctx.Param("slug")))
+	if errors.Is(err, cache.ErrSlugNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	serveStream(ctx, slug.MessageID, slug.Token)
+}
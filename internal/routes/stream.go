@@ -2,13 +2,16 @@ package routes
 
 import (
 	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
 	"EverythingSuckz/fsb/internal/utils"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 
-	"github.com/gotd/td/tg"
+	"github.com/celestix/gotgproto"
 	range_parser "github.com/quantumsheep/range-parser"
 	"go.uber.org/zap"
 
@@ -23,9 +26,18 @@ func (e *allRoutes) LoadHome(r *Route) {
 	r.Engine.GET("/stream/:messageID", getStreamRoute)
 }
 
+// nextWorkerClient lets a stream continue on another worker when the one it
+// started with fails.
+func nextWorkerClient(failed []int64) *gotgproto.Client {
+	worker := bot.GetNextWorkerExcluding(failed)
+	if worker == nil {
+		return nil
+	}
+	return worker.Client
+}
+
 func getStreamRoute(ctx *gin.Context) {
 	w := ctx.Writer
-	r := ctx.Request
 
 	messageIDParm := ctx.Param("messageID")
 	messageID, err := strconv.Atoi(messageIDParm)
@@ -40,6 +52,15 @@ func getStreamRoute(ctx *gin.Context) {
 		return
 	}
 
+	serveStream(ctx, messageID, authHash)
+}
+
+// serveStream checks a link to a message and streams its file. It serves
+// both /stream links and short links.
+func serveStream(ctx *gin.Context, messageID int, authHash string) {
+	w := ctx.Writer
+	r := ctx.Request
+
 	worker := bot.GetNextWorker()
 
 	file, err := utils.FileFromMessage(ctx, worker.Client, messageID)
@@ -48,84 +69,152 @@ func getStreamRoute(ctx *gin.Context) {
 		return
 	}
 
-	expectedHash := utils.PackFile(
-		file.FileName,
-		file.FileSize,
-		file.MimeType,
-		file.ID,
-	)
-	if !utils.CheckHash(authHash, expectedHash) {
-		http.Error(w, "invalid hash", http.StatusBadRequest)
+	claims, ok := authorize(ctx, messageID, authHash, file)
+	if !ok {
+		return
+	}
+
+	kind := utils.LinkStream
+	if ctx.Query("d") == "true" || claims.DownloadOnly() {
+		kind = utils.LinkDownload
+	}
+	if !checkHotlink(ctx, kind, messageID, authHash) {
+		return
+	}
+
+	if !requirePassword(ctx, messageID, authHash, true) {
 		return
 	}
 
-	// for photo messages
-	if file.FileSize == 0 {
-		res, err := worker.Client.API().UploadGetFile(ctx, &tg.UploadGetFileRequest{
-			Location: file.Location,
-			Offset:   0,
-			Limit:    1024 * 1024,
-		})
+	// photos may be requested in any of their sizes
+	if size := ctx.Query("size"); size != "" && file.IsPhoto() {
+		file, err = utils.FileWithThumb(file, size)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		result, ok := res.(*tg.UploadFile)
+	}
+
+	setValidators(ctx, file)
+	if checkNotModified(ctx, file) {
+		return
+	}
+
+	var body io.Writer = w
+	if r.Method != "HEAD" {
+		throttled, release, ok := throttle(ctx, w)
 		if !ok {
-			http.Error(w, "unexpected response", http.StatusInternalServerError)
 			return
 		}
-		fileBytes := result.GetBytes()
-		ctx.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", file.FileName))
-		if r.Method != "HEAD" {
-			ctx.Data(http.StatusOK, file.MimeType, fileBytes)
-		}
-		return
+		defer release()
+		throttled, releaseLink := throttleLink(ctx, throttled, cache.TokenDigest(authHash))
+		defer releaseLink()
+		body = throttled
 	}
 
 	ctx.Header("Accept-Ranges", "bytes")
-	var start, end int64
-	rangeHeader := r.Header.Get("Range")
-
-	if rangeHeader == "" {
-		start = 0
-		end = file.FileSize - 1
-		w.WriteHeader(http.StatusOK)
-	} else {
-		ranges, err := range_parser.Parse(file.FileSize, r.Header.Get("Range"))
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+	mimeType := file.MimeType
+
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	disposition := "inline"
+
+	if kind == utils.LinkDownload {
+		disposition = "attachment"
+	}
+
+	ctx.Header("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, file.FileName))
+
+	var ranges []*range_parser.Range
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && rangeAllowed(r, file) {
+		ranges, err = parseRanges(file.FileSize, rangeHeader)
+		if errors.Is(err, errUnsatisfiableRange) {
+			ctx.Header("Content-Range", fmt.Sprintf("bytes */%d", file.FileSize))
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
 			return
 		}
+	}
+
+	if len(ranges) > 1 {
+		serveMultipartRanges(ctx, body, worker, messageID, file, claims, mimeType, ranges)
+		return
+	}
+
+	start, end := int64(0), file.FileSize-1
+	status := http.StatusOK
+	if len(ranges) == 1 {
 		start = ranges[0].Start
 		end = ranges[0].End
 		ctx.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.FileSize))
 		log.Info("Content-Range", zap.Int64("start", start), zap.Int64("end", end), zap.Int64("fileSize", file.FileSize))
-		w.WriteHeader(http.StatusPartialContent)
+		status = http.StatusPartialContent
 	}
 
 	contentLength := end - start + 1
-	mimeType := file.MimeType
-
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
-	}
-
 	ctx.Header("Content-Type", mimeType)
 	ctx.Header("Content-Length", strconv.FormatInt(contentLength, 10))
+	w.WriteHeader(status)
 
-	disposition := "inline"
+	if r.Method != "HEAD" {
+		out, done := trackUsage(body, claims)
+		err := copyRange(ctx, out, worker, messageID, file, start, end)
+		done(err == nil && start == 0 && end == file.FileSize-1)
+		if err != nil {
+			log.Error("Error while copying stream", zap.Error(err))
+		}
+	}
+}
 
-	if ctx.Query("d") == "true" {
-		disposition = "attachment"
+// copyRange streams the bytes start..end (inclusive) of a file to w.
+func copyRange(ctx *gin.Context, w io.Writer, worker *bot.Worker, messageID int, file *types.File, start int64, end int64) error {
+	contentLength := end - start + 1
+	lr, err := utils.NewTelegramReader(ctx.Request.Context(), worker.Client, nextWorkerClient, messageID, file, start, end, contentLength)
+	if err != nil {
+		return err
 	}
+	defer lr.Close()
+	_, err = io.CopyN(w, lr, contentLength)
+	return err
+}
 
-	ctx.Header("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, file.FileName))
+// serveMultipartRanges sends several ranges of a file, writing the response
+// body to body.
+func serveMultipartRanges(ctx *gin.Context, body io.Writer, worker *bot.Worker, messageID int, file *types.File, claims *utils.LinkClaims, mimeType string, ranges []*range_parser.Range) {
+	w := ctx.Writer
+	out, done := trackUsage(body, claims)
+	defer done(false)
+	mr, err := newMultipartRanges(out, "", mimeType, file.FileSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	contentLength, err := multipartLength(ranges, mr.writer.Boundary(), mimeType, file.FileSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ctx.Header("Content-Type", mr.ContentType())
+	ctx.Header("Content-Length", strconv.FormatInt(contentLength, 10))
+	log.Info("Multipart ranges", zap.Int("count", len(ranges)), zap.Int64("fileSize", file.FileSize))
+	w.WriteHeader(http.StatusPartialContent)
 
-	if r.Method != "HEAD" {
-		lr, _ := utils.NewTelegramReader(ctx, worker.Client, file.Location, start, end, contentLength)
-		if _, err := io.CopyN(w, lr, contentLength); err != nil {
+	if ctx.Request.Method == "HEAD" {
+		return
+	}
+	for _, ra := range ranges {
+		part, err := mr.CreatePart(ra)
+		if err != nil {
+			log.Error("Error while writing part header", zap.Error(err))
+			return
+		}
+		if err := copyRange(ctx, part, worker, messageID, file, ra.Start, ra.End); err != nil {
 			log.Error("Error while copying stream", zap.Error(err))
+			return
 		}
 	}
+	if err := mr.Close(); err != nil {
+		log.Error("Error while closing multipart body", zap.Error(err))
+	}
 }
@@ -0,0 +1,166 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// kibibyte is the unit of the rate limits in config.
+const kibibyte = 1024
+
+// limiterSet shares one token bucket between the streams of each key, like
+// an IP or a link. Buckets are dropped once their last stream ends.
+type limiterSet struct {
+	bytesPerSecond int64
+	mu             sync.Mutex
+	entries        map[string]*limiterEntry
+}
+
+type limiterEntry struct {
+	limiter *rate.Limiter
+	streams int
+}
+
+var (
+	throttleOnce  sync.Once
+	globalLimiter *rate.Limiter
+	ipLimiters    *limiterSet
+	linkLimiters  *limiterSet
+)
+
+func newLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	// the bucket holds a second worth of bytes
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))
+}
+
+func newLimiterSet(bytesPerSecond int64) *limiterSet {
+	return &limiterSet{
+		bytesPerSecond: bytesPerSecond,
+		entries:        make(map[string]*limiterEntry),
+	}
+}
+
+// acquire counts a new stream of key and returns the bucket it shares, nil
+// when there is no rate limit. ok is false when key already has maxStreams
+// streams, 0 allowing any number.
+func (s *limiterSet) acquire(key string, maxStreams int) (limiter *rate.Limiter, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, found := s.entries[key]
+	if !found {
+		entry = &limiterEntry{limiter: newLimiter(s.bytesPerSecond)}
+		s.entries[key] = entry
+	}
+	if maxStreams > 0 && entry.streams >= maxStreams {
+		return nil, false
+	}
+	entry.streams++
+	return entry.limiter, true
+}
+
+func (s *limiterSet) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, found := s.entries[key]
+	if !found {
+		return
+	}
+	entry.streams--
+	if entry.streams <= 0 {
+		delete(s.entries, key)
+	}
+}
+
+// throttledWriter waits for every bucket it is limited by before writing.
+type throttledWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*rate.Limiter
+	chunk    int
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), t.chunk)
+		for _, limiter := range t.limiters {
+			if err := limiter.WaitN(t.ctx, n); err != nil {
+				return written, err
+			}
+		}
+		m, err := t.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func initLimiters() {
+	throttleOnce.Do(func() {
+		globalLimiter = newLimiter(config.ValueOf.GlobalRateLimit * kibibyte)
+		ipLimiters = newLimiterSet(config.ValueOf.IPRateLimit * kibibyte)
+		linkLimiters = newLimiterSet(config.ValueOf.LinkRateLimit * kibibyte)
+	})
+}
+
+func throttledTo(ctx *gin.Context, w io.Writer, limiters []*rate.Limiter) io.Writer {
+	if len(limiters) == 0 {
+		return w
+	}
+	chunk := limiters[0].Burst()
+	for _, limiter := range limiters[1:] {
+		chunk = min(chunk, limiter.Burst())
+	}
+	return &throttledWriter{ctx: ctx.Request.Context(), w: w, limiters: limiters, chunk: chunk}
+}
+
+// throttle applies the global and per-IP rate limits to w. release has to be
+// called when the stream ends. When the client already has
+// MAX_STREAMS_PER_IP streams, the request is answered and ok is false.
+func throttle(ctx *gin.Context, w io.Writer) (out io.Writer, release func(), ok bool) {
+	initLimiters()
+
+	ip := ctx.ClientIP()
+	ipLimiter, ok := ipLimiters.acquire(ip, config.ValueOf.MaxStreamsPerIP)
+	if !ok {
+		log.Warn("Too many streams", zap.String("ip", ip), zap.Int("max", config.ValueOf.MaxStreamsPerIP))
+		http.Error(ctx.Writer, "too many streams at once, try again later", http.StatusTooManyRequests)
+		return nil, nil, false
+	}
+
+	var limiters []*rate.Limiter
+	if globalLimiter != nil {
+		limiters = append(limiters, globalLimiter)
+	}
+	if ipLimiter != nil {
+		limiters = append(limiters, ipLimiter)
+	}
+	return throttledTo(ctx, w, limiters), func() { ipLimiters.release(ip) }, true
+}
+
+// throttleLink applies the per-link rate limit to w. Links are told apart by
+// the cache.TokenDigest of their token, which is all that bundles carry of
+// the links they were made of. release has to be called once the file has
+// been written.
+func throttleLink(ctx *gin.Context, w io.Writer, digest string) (out io.Writer, release func()) {
+	initLimiters()
+	limiter, _ := linkLimiters.acquire(digest, 0)
+	release = func() { linkLimiters.release(digest) }
+	if limiter == nil {
+		return w, release
+	}
+	return throttledTo(ctx, w, []*rate.Limiter{limiter}), release
+}
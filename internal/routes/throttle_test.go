@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+func TestLimiterSet(t *testing.T) {
+	tests := []struct {
+		name       string
+		rate       int64
+		maxStreams int
+		open       int // streams of the key before
+		ok         bool
+	}{
+		{name: "first stream", rate: 1024, maxStreams: 2, ok: true},
+		{name: "below the limit", rate: 1024, maxStreams: 2, open: 1, ok: true},
+		{name: "at the limit", rate: 1024, maxStreams: 2, open: 2},
+		{name: "no limit", rate: 1024, open: 5, ok: true},
+		{name: "no rate limit", maxStreams: 2, open: 1, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newLimiterSet(tt.rate)
+			var shared *rate.Limiter
+			for i := 0; i < tt.open; i++ {
+				shared, _ = s.acquire("ip", tt.maxStreams)
+			}
+			limiter, ok := s.acquire("ip", tt.maxStreams)
+			if ok != tt.ok {
+				t.Fatalf("acquire = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if (limiter == nil) != (tt.rate == 0) {
+				t.Fatalf("acquire returned limiter %v with a rate of %d", limiter, tt.rate)
+			}
+			if tt.open > 0 && limiter != shared {
+				t.Fatal("the streams of a key don't share their bucket")
+			}
+			if other, _ := s.acquire("other", tt.maxStreams); other != nil && other == limiter {
+				t.Fatal("two keys share a bucket")
+			}
+			s.release("other")
+			for i := 0; i <= tt.open; i++ {
+				s.release("ip")
+			}
+			if len(s.entries) != 0 {
+				t.Fatalf("%d buckets left after the streams ended", len(s.entries))
+			}
+		})
+	}
+}
+
+// recordingWriter keeps the sizes of the writes it got.
+type recordingWriter struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestThrottledWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		bursts   []int
+		size     int
+		writes   []int
+		canceled bool
+	}{
+		{name: "single write", bursts: []int{1024}, size: 1000, writes: []int{1000}},
+		{name: "split by burst", bursts: []int{1024}, size: 2500, writes: []int{1024, 1024, 452}},
+		{name: "smallest burst", bursts: []int{1024, 512}, size: 1024, writes: []int{512, 512}},
+		{name: "canceled", bursts: []int{1024}, size: 1000, canceled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.canceled {
+				cancel()
+			}
+			var limiters []*rate.Limiter
+			for _, burst := range tt.bursts {
+				// fast enough for the test to never wait long
+				limiters = append(limiters, rate.NewLimiter(rate.Limit(1<<20), burst))
+			}
+			w := &recordingWriter{}
+			request := &gin.Context{Request: httptest.NewRequest(http.MethodGet, "/stream/1", nil).WithContext(ctx)}
+			throttled := throttledTo(request, w, limiters)
+
+			data := bytes.Repeat([]byte{7}, tt.size)
+			n, err := throttled.Write(data)
+			if tt.canceled {
+				if err == nil || n != 0 {
+					t.Fatalf("Write = %d, %v, want a context error", n, err)
+				}
+				return
+			}
+			if err != nil || n != tt.size {
+				t.Fatalf("Write = %d, %v, want %d", n, err, tt.size)
+			}
+			if !bytes.Equal(w.Bytes(), data) {
+				t.Fatal("the data changed on the way")
+			}
+			if len(w.writes) != len(tt.writes) {
+				t.Fatalf("writes = %v, want %v", w.writes, tt.writes)
+			}
+			for i := range tt.writes {
+				if w.writes[i] != tt.writes[i] {
+					t.Fatalf("writes = %v, want %v", w.writes, tt.writes)
+				}
+			}
+		})
+	}
+}
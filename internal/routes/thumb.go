@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func (e *allRoutes) LoadThumb(r *Route) {
+	defer e.log.Named("Thumb").Info("Loaded thumb route")
+	r.Engine.GET("/thumb/:messageID", e.getThumbRoute)
+}
+
+func (e *allRoutes) getThumbRoute(ctx *gin.Context) {
+	w := ctx.Writer
+	r := ctx.Request
+
+	messageID, err := strconv.Atoi(ctx.Param("messageID"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	authHash := ctx.Query("hash")
+	if authHash == "" {
+		http.Error(w, "missing hash param", http.StatusBadRequest)
+		return
+	}
+
+	worker := bot.GetNextWorker()
+
+	file, err := utils.FileFromMessage(ctx, worker.Client, messageID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := authorize(ctx, messageID, authHash, file); !ok {
+		return
+	}
+
+	// thumbnails are embedded by the watch page like the stream itself
+	if !checkHotlink(ctx, utils.LinkStream, messageID, authHash) {
+		return
+	}
+
+	if !requirePassword(ctx, messageID, authHash, false) {
+		return
+	}
+
+	thumbType := ctx.Query("size")
+	if thumbType == "" {
+		thumbType = defaultThumbType(file)
+	}
+	if thumbType == "" {
+		http.Error(w, "file has no thumbnail", http.StatusNotFound)
+		return
+	}
+	thumb, err := utils.FileWithThumb(file, thumbType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if thumb.FileSize <= 0 {
+		http.Error(w, "thumbnail is empty", http.StatusNotFound)
+		return
+	}
+
+	// thumbnails never change, so clients may keep them for a long time
+	ctx.Header("Cache-Control", "public, max-age=604800")
+	setValidators(ctx, thumb)
+	if checkNotModified(ctx, thumb) {
+		return
+	}
+
+	ctx.Header("Content-Type", thumb.MimeType)
+	ctx.Header("Content-Length", strconv.FormatInt(thumb.FileSize, 10))
+	ctx.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", thumb.FileName))
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method != "HEAD" {
+		if err := copyRange(ctx, w, worker, messageID, thumb, 0, thumb.FileSize-1); err != nil {
+			e.log.Error("Error while copying thumbnail", zap.Error(err))
+		}
+	}
+}
+
+// defaultThumbType picks the largest thumbnail of a document, or for photos
+// the largest size below the full resolution one.
+func defaultThumbType(file *types.File) string {
+	thumbs := file.Thumbs
+	if file.IsPhoto() && len(thumbs) > 1 {
+		thumbs = thumbs[:len(thumbs)-1]
+	}
+	if len(thumbs) == 0 {
+		return ""
+	}
+	return thumbs[len(thumbs)-1].Type
+}
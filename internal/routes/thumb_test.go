@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/types"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestDefaultThumbType(t *testing.T) {
+	document := &tg.InputDocumentFileLocation{ID: 7}
+	photo := &tg.InputPhotoFileLocation{ID: 7, ThumbSize: "y"}
+	tests := []struct {
+		name     string
+		location tg.InputFileLocationClass
+		thumbs   []types.Thumb
+		want     string
+	}{
+		{name: "document", location: document, thumbs: []types.Thumb{{Type: "s"}, {Type: "m"}}, want: "m"},
+		{name: "document without thumbnails", location: document},
+		{name: "photo", location: photo, thumbs: []types.Thumb{{Type: "s"}, {Type: "m"}, {Type: "y"}}, want: "m"},
+		// the full resolution is all there is
+		{name: "photo with a single size", location: photo, thumbs: []types.Thumb{{Type: "y"}}, want: "y"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &types.File{Location: tt.location, Thumbs: tt.thumbs}
+			if got := defaultThumbType(file); got != tt.want {
+				t.Fatalf("defaultThumbType = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/utils"
+	"io"
+
+	"go.uber.org/zap"
+)
+
+// usageFlushSize is how many bytes are served before they are added to the
+// counters of a limited link.
+const usageFlushSize = 1024 * 1024
+
+// usageWriter counts the bytes served through a link with limits and cuts
+// the response once the link runs out of bandwidth.
+type usageWriter struct {
+	w       io.Writer
+	links   *cache.LinkStore
+	linkID  uint
+	pending int64
+	usedUp  bool
+}
+
+func (u *usageWriter) Write(p []byte) (int, error) {
+	if u.usedUp {
+		return 0, utils.ErrLinkUsedUp
+	}
+	n, err := u.w.Write(p)
+	u.pending += int64(n)
+	if u.pending >= usageFlushSize {
+		u.flush()
+	}
+	return n, err
+}
+
+func (u *usageWriter) flush() {
+	if u.pending == 0 {
+		return
+	}
+	usedUp, err := u.links.AddBytes(u.linkID, u.pending)
+	if err != nil {
+		log.Error("Failed to count link usage", zap.Uint("linkID", u.linkID), zap.Error(err))
+	}
+	u.pending = 0
+	u.usedUp = usedUp
+}
+
+// trackUsage wraps w to count the bytes served through a link with limits.
+// done has to be called once the file has been written, telling whether the
+// whole file was sent, which counts as a download.
+func trackUsage(w io.Writer, claims *utils.LinkClaims) (io.Writer, func(complete bool)) {
+	links := cache.GetLinks()
+	if claims.LinkID == 0 || links == nil {
+		return w, func(bool) {}
+	}
+	u := &usageWriter{w: w, links: links, linkID: claims.LinkID}
+	return u, func(complete bool) {
+		u.flush()
+		if complete {
+			if _, err := links.AddDownload(claims.LinkID); err != nil {
+				log.Error("Failed to count link download", zap.Uint("linkID", claims.LinkID), zap.Error(err))
+			}
+		}
+	}
+}
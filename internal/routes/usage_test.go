@@ -0,0 +1,94 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// withLinks backs the links with limits with an empty in-memory database.
+func withLinks(t *testing.T) *cache.LinkStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would get a database of its own
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&types.Link{}); err != nil {
+		t.Fatal(err)
+	}
+	old := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = old })
+	cache.InitLinks(zap.NewNop())
+	return cache.GetLinks()
+}
+
+func TestTrackUsage(t *testing.T) {
+	links := withLinks(t)
+	chunk := make([]byte, usageFlushSize)
+	tests := []struct {
+		name      string
+		link      types.Link
+		writes    int
+		complete  bool
+		written   int
+		downloads int64
+	}{
+		{name: "complete download", link: types.Link{MaxDownloads: 2}, writes: 3, complete: true, written: 3, downloads: 1},
+		{name: "cut off download", link: types.Link{MaxDownloads: 2}, writes: 3, written: 3},
+		// the writes after the bytes ran out fail
+		{name: "out of bytes", link: types.Link{MaxBytes: 2 * usageFlushSize}, writes: 4, written: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link := tt.link
+			if err := links.Create(&link); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			w, done := trackUsage(&out, &utils.LinkClaims{LinkID: link.ID})
+			written := 0
+			for i := 0; i < tt.writes; i++ {
+				if _, err := w.Write(chunk); err != nil {
+					if !errors.Is(err, utils.ErrLinkUsedUp) {
+						t.Fatal(err)
+					}
+					break
+				}
+				written++
+			}
+			done(tt.complete)
+			if written != tt.written {
+				t.Fatalf("%d writes went through, want %d", written, tt.written)
+			}
+			stored, err := links.Get(link.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.BytesServed != int64(tt.written*usageFlushSize) || stored.Downloads != tt.downloads {
+				t.Fatalf("link served %d bytes in %d downloads, want %d in %d",
+					stored.BytesServed, stored.Downloads, tt.written*usageFlushSize, tt.downloads)
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	if w, _ := trackUsage(&out, &utils.LinkClaims{}); w != &out {
+		t.Fatal("links without limits are tracked")
+	}
+}
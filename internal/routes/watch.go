@@ -0,0 +1,122 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/utils"
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var watchTemplate = template.Must(template.ParseFS(templateFS, "templates/watch.html"))
+
+type watchPage struct {
+	Name        string
+	Size        string
+	MimeType    string
+	Viewer      string
+	StreamURL   string
+	DownloadURL string
+	PosterURL   string
+}
+
+func (e *allRoutes) LoadWatch(r *Route) {
+	defer e.log.Named("Watch").Info("Loaded watch route")
+	r.Engine.GET("/watch/:messageID", e.getWatchRoute)
+}
+
+func (e *allRoutes) getWatchRoute(ctx *gin.Context) {
+	w := ctx.Writer
+
+	messageID, err := strconv.Atoi(ctx.Param("messageID"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	authHash := ctx.Query("hash")
+	if authHash == "" {
+		http.Error(w, "missing hash param", http.StatusBadRequest)
+		return
+	}
+
+	worker := bot.GetNextWorker()
+
+	file, err := utils.FileFromMessage(ctx, worker.Client, messageID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	claims, ok := authorize(ctx, messageID, authHash, file)
+	if !ok {
+		return
+	}
+
+	if !checkHotlink(ctx, utils.LinkWatch, messageID, authHash) {
+		return
+	}
+
+	if !requirePassword(ctx, messageID, authHash, true) {
+		return
+	}
+
+	query := url.Values{"hash": {authHash}}
+	streamURL := fmt.Sprintf("/stream/%d?%s", messageID, query.Encode())
+	query.Set("d", "true")
+	downloadURL := fmt.Sprintf("/stream/%d?%s", messageID, query.Encode())
+
+	// download only links can't be played in the page
+	if claims.DownloadOnly() {
+		ctx.Redirect(http.StatusFound, downloadURL)
+		return
+	}
+
+	page := watchPage{
+		Name:        file.FileName,
+		Size:        utils.FormatFileSize(file.FileSize),
+		MimeType:    file.MimeType,
+		Viewer:      viewerFor(file.MimeType),
+		StreamURL:   streamURL,
+		DownloadURL: downloadURL,
+	}
+	if page.Viewer == "video" && !file.IsPhoto() && len(file.Thumbs) > 0 {
+		page.PosterURL = fmt.Sprintf("/thumb/%d?hash=%s", messageID, url.QueryEscape(authHash))
+	}
+
+	var body bytes.Buffer
+	if err := watchTemplate.Execute(&body, page); err != nil {
+		e.log.Error("Failed to render watch page", zap.Error(err))
+		http.Error(w, "failed to render page", http.StatusInternalServerError)
+		return
+	}
+	ctx.Data(http.StatusOK, "text/html; charset=utf-8", body.Bytes())
+}
+
+// viewerFor picks the HTML element a file is shown with. Files browsers can't
+// display only get the download button.
+func viewerFor(mimeType string) string {
+	mimeType = strings.ToLower(mimeType)
+	switch {
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	case mimeType == "application/pdf":
+		return "pdf"
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package routes
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestViewerFor(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		viewer   string
+	}{
+		{mimeType: "video/mp4", viewer: "video"},
+		{mimeType: "Video/x-matroska", viewer: "video"},
+		{mimeType: "audio/mpeg", viewer: "audio"},
+		{mimeType: "image/jpeg", viewer: "image"},
+		{mimeType: "application/pdf", viewer: "pdf"},
+		{mimeType: "application/zip"},
+		{mimeType: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mimeType, func(t *testing.T) {
+			if viewer := viewerFor(tt.mimeType); viewer != tt.viewer {
+				t.Fatalf("viewerFor = %q, want %q", viewer, tt.viewer)
+			}
+		})
+	}
+}
+
+func TestWatchTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		page    watchPage
+		element string
+	}{
+		{name: "video", page: watchPage{Viewer: "video", PosterURL: "/thumb/1?hash=abc"}, element: `poster="/thumb/1?hash=abc"`},
+		{name: "audio", page: watchPage{Viewer: "audio"}, element: "<audio"},
+		{name: "image", page: watchPage{Viewer: "image"}, element: "<img"},
+		{name: "pdf", page: watchPage{Viewer: "pdf"}, element: "<iframe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.page.Name = `<script>alert(1)</script>.mp4`
+			tt.page.StreamURL = "/stream/1?hash=abc"
+			tt.page.DownloadURL = "/stream/1?d=true&hash=abc"
+			var body bytes.Buffer
+			if err := watchTemplate.Execute(&body, tt.page); err != nil {
+				t.Fatal(err)
+			}
+			page := body.String()
+			if !strings.Contains(page, tt.element) {
+				t.Fatalf("page has no %s", tt.element)
+			}
+			if strings.Contains(page, "<script>alert") {
+				t.Fatal("the file name was not escaped")
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"EverythingSuckz/fsb/pkg/zipstream"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// maxZipFiles caps how many files a single archive may contain.
+const maxZipFiles = 100
+
+var errRepeatedLink = errors.New("a link with limits can only be used once per archive")
+
+func (e *allRoutes) LoadZip(r *Route) {
+	defer e.log.Named("Zip").Info("Loaded zip route")
+	r.Engine.GET("/zip", e.getZipRoute)
+	r.Engine.HEAD("/zip", e.getZipRoute)
+	r.Engine.GET("/api/bundle", e.getBundle)
+}
+
+type zipRequest struct {
+	messageIDs []int
+	// hashes holds the link hash of each file, or nil for a bundle token
+	hashes []string
+	bundle *utils.Bundle
+	token  string
+}
+
+// parseZipRequest reads either ?files=<id>:<hash>,... or ?bundle=<token>.
+func parseZipRequest(ctx *gin.Context) (*zipRequest, error) {
+	req := &zipRequest{}
+	if token := ctx.Query("bundle"); token != "" {
+		bundle, err := utils.ParseBundleToken(token)
+		if err != nil {
+			return nil, err
+		}
+		req.messageIDs = bundle.MessageIDs()
+		req.bundle = bundle
+		req.token = token
+	} else if files := ctx.Query("files"); files != "" {
+		for _, item := range strings.Split(files, ",") {
+			id, hash, ok := strings.Cut(item, ":")
+			if !ok || hash == "" {
+				return nil, fmt.Errorf("missing hash for %q", item)
+			}
+			messageID, err := strconv.Atoi(id)
+			if err != nil {
+				return nil, err
+			}
+			req.messageIDs = append(req.messageIDs, messageID)
+			req.hashes = append(req.hashes, hash)
+		}
+	} else {
+		return nil, errors.New("missing files or bundle param")
+	}
+	if len(req.messageIDs) > maxZipFiles {
+		return nil, fmt.Errorf("too many files, at most %d are allowed", maxZipFiles)
+	}
+	return req, nil
+}
+
+// linkDigest identifies the link the i-th file was requested with, for the
+// per-link rate limit.
+func (req *zipRequest) linkDigest(i int) string {
+	if req.bundle != nil {
+		return req.bundle.Members[i].Source
+	}
+	return cache.TokenDigest(req.hashes[i])
+}
+
+// files fetches and authorizes every file of the request, returning the
+// claims of their links alongside.
+func (req *zipRequest) files(ctx *gin.Context, worker *bot.Worker) ([]*types.File, []*utils.LinkClaims, error) {
+	files := make([]*types.File, len(req.messageIDs))
+	claims := make([]*utils.LinkClaims, len(req.messageIDs))
+	limited := make(map[uint]bool)
+	// the MAC of a bundle was checked while parsing it, its other claims
+	// are checked here, both before anything is asked from Telegram
+	if req.bundle != nil {
+		if err := utils.VerifyBundle(req.token, req.bundle); err != nil {
+			return nil, nil, err
+		}
+	}
+	for i, messageID := range req.messageIDs {
+		file, err := utils.FileFromMessage(ctx, worker.Client, messageID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("message %d: %w", messageID, err)
+		}
+		if req.bundle != nil {
+			claims[i] = req.bundle.Claims(i)
+		} else {
+			claims[i], err = utils.VerifyLink(req.hashes[i], messageID, file)
+			if err != nil {
+				return nil, nil, fmt.Errorf("message %d: %w", messageID, err)
+			}
+		}
+		// the limits are checked once per file, so repeating a link would
+		// get past them
+		if linkID := claims[i].LinkID; linkID != 0 {
+			if limited[linkID] {
+				return nil, nil, fmt.Errorf("message %d: %w", messageID, errRepeatedLink)
+			}
+			limited[linkID] = true
+		}
+		files[i] = file
+	}
+	if req.bundle != nil {
+		if err := req.bundle.MatchFiles(files); err != nil {
+			return nil, nil, err
+		}
+	}
+	return files, claims, nil
+}
+
+func (e *allRoutes) getZipRoute(ctx *gin.Context) {
+	w := ctx.Writer
+	r := ctx.Request
+
+	req, err := parseZipRequest(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	worker := bot.GetNextWorker()
+
+	files, claims, err := req.files(ctx, worker)
+	if err != nil {
+		http.Error(w, err.Error(), linkErrorStatus(err))
+		return
+	}
+
+	if !checkReferer(ctx, utils.LinkDownload) {
+		return
+	}
+	for i, messageID := range req.messageIDs {
+		hash := req.token
+		if req.hashes != nil {
+			hash = req.hashes[i]
+		}
+		if !checkBinding(ctx, messageID, hash) {
+			return
+		}
+		if !requirePassword(ctx, messageID, hash, false) {
+			return
+		}
+	}
+
+	var body io.Writer = w
+	if r.Method != "HEAD" {
+		throttled, release, ok := throttle(ctx, w)
+		if !ok {
+			return
+		}
+		defer release()
+		body = throttled
+	}
+
+	entries := zipEntries(files)
+	archiveName := ctx.DefaultQuery("name", "files")
+	if !strings.HasSuffix(strings.ToLower(archiveName), ".zip") {
+		archiveName += ".zip"
+	}
+
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Header("Content-Length", strconv.FormatInt(zipstream.Size(entries), 10))
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", archiveName))
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == "HEAD" {
+		return
+	}
+
+	zw := zipstream.NewWriter(body)
+	for i, entry := range entries {
+		part, err := zw.Create(entry)
+		if err != nil {
+			e.log.Error("Error while writing zip entry header", zap.Error(err))
+			return
+		}
+		if entry.Size == 0 {
+			continue
+		}
+		// other downloads may have used up the link since the request began
+		if err := utils.CheckLinkUsage(claims[i].LinkID); err != nil {
+			e.log.Info("Stopping zip archive", zap.Int("messageID", req.messageIDs[i]), zap.Error(err))
+			return
+		}
+		throttled, releaseLink := throttleLink(ctx, part, req.linkDigest(i))
+		out, done := trackUsage(throttled, claims[i])
+		err = copyRange(ctx, out, worker, req.messageIDs[i], files[i], 0, entry.Size-1)
+		done(err == nil)
+		releaseLink()
+		if err != nil {
+			e.log.Error("Error while copying zip entry", zap.Int("messageID", req.messageIDs[i]), zap.Error(err))
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		e.log.Error("Error while closing zip archive", zap.Error(err))
+	}
+}
+
+// zipEntries names the archive entries after the files, numbering duplicate
+// names the way browsers do for repeated downloads.
+func zipEntries(files []*types.File) []zipstream.Entry {
+	entries := make([]zipstream.Entry, len(files))
+	seen := make(map[string]int)
+	for i, file := range files {
+		name := path.Base(strings.ReplaceAll(file.FileName, "\\", "/"))
+		if name == "." || name == "/" || name == "" {
+			name = fmt.Sprintf("file_%d", file.ID)
+		}
+		if n := seen[name]; n > 0 {
+			ext := path.Ext(name)
+			seen[name]++
+			name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+		} else {
+			seen[name] = 1
+		}
+		entries[i] = zipstream.Entry{
+			Name:     name,
+			Size:     file.FileSize,
+			Modified: time.Unix(int64(file.Date), 0),
+		}
+	}
+	return entries
+}
+
+// getBundle turns a ?files= list into a shorter bundle token. The bundle
+// takes over the expiry, flags and limits of the links, and stops working
+// when one of them is revoked.
+func (e *allRoutes) getBundle(ctx *gin.Context) {
+	req, err := parseZipRequest(ctx)
+	if err == nil && req.bundle != nil {
+		err = errors.New("bundles are made from a files list")
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	files, claims, err := req.files(ctx, bot.GetNextWorker())
+	if err != nil {
+		ctx.JSON(linkErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	token := utils.BundleToken(utils.NewBundle(req.messageIDs, req.hashes, claims, files))
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"token":   token,
+		"url":     fmt.Sprintf("%s/zip?bundle=%s", config.ValueOf.Host, token),
+	})
+}
@@ -0,0 +1,19 @@
+package types
+
+import (
+	"time"
+)
+
+// LinkBinding ties a signed link to the network of the client that used it
+// first.
+type LinkBinding struct {
+	Token     string    `gorm:"primaryKey" json:"token"`
+	MessageID int       `gorm:"index;not null;default:0" json:"message_id"`
+	Network   string    `gorm:"not null" json:"network"` // CIDR prefix, eg. 203.0.113.0/24
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// TableName specifies the table name for LinkBinding
+func (LinkBinding) TableName() string {
+	return "link_bindings"
+}
@@ -15,6 +15,32 @@ type File struct {
 	FileName string
 	MimeType string
 	ID       int64
+	Date     int // unix time of the log channel message
+	Thumbs   []Thumb
+}
+
+// Thumb is a downloadable size of a photo or of a document thumbnail. Thumbs
+// are kept in the order Telegram sends them, smallest first.
+type Thumb struct {
+	Type string
+	Size int64
+}
+
+func (f *File) IsPhoto() bool {
+	_, ok := f.Location.(*tg.InputPhotoFileLocation)
+	return ok
+}
+
+// ThumbType returns the size type the file location points at, which is
+// empty for the document itself.
+func (f *File) ThumbType() string {
+	switch location := f.Location.(type) {
+	case *tg.InputPhotoFileLocation:
+		return location.ThumbSize
+	case *tg.InputDocumentFileLocation:
+		return location.ThumbSize
+	}
+	return ""
 }
 
 type HashableFileStruct struct {
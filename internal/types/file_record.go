@@ -0,0 +1,46 @@
+package types
+
+import (
+	"time"
+)
+
+// FileRecord is a file sent to the bot and forwarded to the log channel. It
+// keeps what is needed to stream the file without asking Telegram for the
+// log channel message again.
+type FileRecord struct {
+	ID            uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	MessageID     int       `gorm:"index;not null" json:"message_id"` // log channel message
+	UploaderID    int64     `gorm:"index;not null" json:"uploader_id"`
+	DocumentID    int64     `gorm:"index;not null" json:"document_id"` // document or photo ID
+	AccessHash    int64     `gorm:"not null" json:"-"`
+	FileReference []byte    `json:"-"`
+	Photo         bool      `gorm:"not null;default:false" json:"photo"`
+	ThumbSize     string    `gorm:"not null;default:''" json:"-"` // size the location of photos points at
+	Thumbs        string    `gorm:"not null;default:''" json:"-"` // JSON of []Thumb
+	FileName      string    `json:"file_name"`
+	DisplayName   string    `gorm:"not null;default:''" json:"display_name,omitempty"` // set when the uploader renames it
+	FileSize      int64     `json:"file_size"`
+	MimeType      string    `json:"mime_type"`
+	Hash          string    `gorm:"index" json:"hash"` // full legacy hash of the file
+	MessageDate   time.Time `json:"message_date"`
+	Hidden        bool      `gorm:"not null;default:false" json:"hidden"` // left out of the history, only records the upload
+	Gone          bool      `gorm:"not null;default:false" json:"gone"`   // the log channel message was deleted
+	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Name returns the name of the file as shown in the history.
+func (r *FileRecord) Name() string {
+	if r.DisplayName != "" {
+		return r.DisplayName
+	}
+	if r.FileName != "" {
+		return r.FileName
+	}
+	return "unnamed file"
+}
+
+// TableName specifies the table name for FileRecord
+func (FileRecord) TableName() string {
+	return "files"
+}
@@ -0,0 +1,30 @@
+package types
+
+import (
+	"time"
+)
+
+// Link is a link with its own record, created with usage limits. Its ID is
+// carried in the signed hash of the link.
+type Link struct {
+	ID           uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	MessageID    int       `gorm:"index;not null" json:"message_id"`
+	MaxDownloads int64     `gorm:"not null;default:0" json:"max_downloads"` // 0 for no limit
+	MaxBytes     int64     `gorm:"not null;default:0" json:"max_bytes"`     // 0 for no limit
+	Downloads    int64     `gorm:"not null;default:0" json:"downloads"`
+	BytesServed  int64     `gorm:"not null;default:0" json:"bytes_served"`
+	CreatedBy    int64     `gorm:"not null;default:0" json:"created_by,omitempty"` // 0 when created through the API
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Exhausted reports whether the link has used up one of its limits.
+func (l *Link) Exhausted() bool {
+	return (l.MaxDownloads > 0 && l.Downloads >= l.MaxDownloads) ||
+		(l.MaxBytes > 0 && l.BytesServed >= l.MaxBytes)
+}
+
+// TableName specifies the table name for Link
+func (Link) TableName() string {
+	return "links"
+}
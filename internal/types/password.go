@@ -0,0 +1,20 @@
+package types
+
+import (
+	"time"
+)
+
+// LinkPassword protects every link of a message with a password. Only the
+// bcrypt hash of the password is stored.
+type LinkPassword struct {
+	MessageID int       `gorm:"primaryKey;autoIncrement:false"`
+	Hash      string    `gorm:"not null"`
+	SetBy     int64     `gorm:"not null;default:0"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+// TableName specifies the table name for LinkPassword
+func (LinkPassword) TableName() string {
+	return "link_passwords"
+}
@@ -0,0 +1,21 @@
+package types
+
+import (
+	"time"
+)
+
+// Revocation denies access to every link of a message, or to a single link
+// when Token is set.
+type Revocation struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	MessageID int       `gorm:"index;not null;default:0" json:"message_id,omitempty"`
+	Token     string    `gorm:"index;not null;default:''" json:"token,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	RevokedBy int64     `gorm:"not null;default:0" json:"revoked_by,omitempty"` // 0 when revoked through the API
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// TableName specifies the table name for Revocation
+func (Revocation) TableName() string {
+	return "revocations"
+}
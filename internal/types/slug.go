@@ -0,0 +1,19 @@
+package types
+
+import (
+	"time"
+)
+
+// Slug is a short name for a link to a message, served at /s/<slug>.
+type Slug struct {
+	Slug      string    `gorm:"primaryKey" json:"slug"`
+	MessageID int       `gorm:"index;not null" json:"message_id"`
+	Token     string    `gorm:"not null" json:"token"` // hash of the link the slug stands for
+	CreatedBy int64     `gorm:"not null;default:0" json:"created_by,omitempty"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// TableName specifies the table name for Slug
+func (Slug) TableName() string {
+	return "slugs"
+}
@@ -6,27 +6,30 @@ import (
 
 // Stats represents the statistics for file processing
 type Stats struct {
-	ID        uint      `gorm:"primaryKey;autoIncrement"`
-	Date      time.Time `gorm:"index;not null"`
-	FileCount int64     `gorm:"not null;default:0"`
-	TotalSize int64     `gorm:"not null;default:0"` // in bytes
-	CreatedAt time.Time `gorm:"autoCreateTime"`
-	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	ID              uint      `gorm:"primaryKey;autoIncrement"`
+	Date            time.Time `gorm:"index;not null"`
+	FileCount       int64     `gorm:"not null;default:0"`
+	TotalSize       int64     `gorm:"not null;default:0"` // in bytes
+	HotlinksBlocked int64     `gorm:"not null;default:0"`
+	CreatedAt       time.Time `gorm:"autoCreateTime"`
+	UpdatedAt       time.Time `gorm:"autoUpdateTime"`
 }
 
 // DailyStats represents today's statistics
 type DailyStats struct {
-	Date      time.Time `json:"date"`
-	FileCount int64     `json:"file_count"`
-	TotalSize int64     `json:"total_size"` // in bytes
+	Date            time.Time `json:"date"`
+	FileCount       int64     `json:"file_count"`
+	TotalSize       int64     `json:"total_size"` // in bytes
+	HotlinksBlocked int64     `json:"hotlinks_blocked"`
 }
 
 // WeeklyStats represents the last 7 days statistics
 type WeeklyStats struct {
-	StartDate time.Time `json:"start_date"`
-	EndDate   time.Time `json:"end_date"`
-	FileCount int64     `json:"file_count"`
-	TotalSize int64     `json:"total_size"` // in bytes
+	StartDate       time.Time `json:"start_date"`
+	EndDate         time.Time `json:"end_date"`
+	FileCount       int64     `json:"file_count"`
+	TotalSize       int64     `json:"total_size"` // in bytes
+	HotlinksBlocked int64     `json:"hotlinks_blocked"`
 }
 
 // StatisticsResponse represents the complete statistics response
@@ -40,4 +43,4 @@ type StatisticsResponse struct {
 // TableName specifies the table name for Stats
 func (Stats) TableName() string {
 	return "file_stats"
-} 
+}
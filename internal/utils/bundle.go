@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+const bundleTokenVersion = 2
+
+var ErrInvalidBundle = errors.New("invalid bundle token")
+
+// BundleMember is a file of a bundle together with the link it was added
+// with.
+type BundleMember struct {
+	MessageID int
+	LinkID    uint   // record of a link with usage limits, 0 for none
+	Source    string // cache.TokenDigest of the link
+	File      string // fileDigest of the file the message held
+}
+
+// Bundle is the content of a bundle token. It carries the strictest claims
+// of the links it was made of, so a bundle never outlives any of them.
+type Bundle struct {
+	Expiry  int64 // unix time, 0 for bundles that never expire
+	Flags   uint8
+	Members []BundleMember
+}
+
+// NewBundle collects the earliest expiry and every flag of the links of a
+// bundle, along with the limited link of each file.
+func NewBundle(messageIDs []int, hashes []string, claims []*LinkClaims, files []*types.File) *Bundle {
+	bundle := &Bundle{Members: make([]BundleMember, len(messageIDs))}
+	for i, messageID := range messageIDs {
+		if expiry := claims[i].Expiry; expiry != 0 && (bundle.Expiry == 0 || expiry < bundle.Expiry) {
+			bundle.Expiry = expiry
+		}
+		bundle.Flags |= claims[i].Flags
+		bundle.Members[i] = BundleMember{
+			MessageID: messageID,
+			LinkID:    claims[i].LinkID,
+			Source:    cache.TokenDigest(hashes[i]),
+			File:      fileDigest(messageID, files[i]),
+		}
+	}
+	return bundle
+}
+
+// fileDigest identifies the file of a message in a bundle token, so the
+// token can be checked before any file is fetched and the files afterwards.
+func fileDigest(messageID int, file *types.File) string {
+	return cache.TokenDigest(linkSubject(messageID, file))
+}
+
+// MessageIDs lists the messages of the bundle in order.
+func (b *Bundle) MessageIDs() []int {
+	messageIDs := make([]int, len(b.Members))
+	for i, member := range b.Members {
+		messageIDs[i] = member.MessageID
+	}
+	return messageIDs
+}
+
+// Claims returns the claims that apply to the i-th file of the bundle.
+func (b *Bundle) Claims(i int) *LinkClaims {
+	return &LinkClaims{Expiry: b.Expiry, Flags: b.Flags, LinkID: b.Members[i].LinkID}
+}
+
+func (b *Bundle) encode() string {
+	buf := []byte{bundleTokenVersion, b.Flags}
+	buf = binary.AppendUvarint(buf, uint64(b.Expiry))
+	buf = binary.AppendUvarint(buf, uint64(len(b.Members)))
+	for _, member := range b.Members {
+		buf = binary.AppendUvarint(buf, uint64(member.MessageID))
+		buf = binary.AppendUvarint(buf, uint64(member.LinkID))
+		buf = append(buf, member.Source...)
+		buf = append(buf, member.File...)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeBundle(payload string) (*Bundle, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || len(buf) < 2 || buf[0] != bundleTokenVersion {
+		return nil, ErrInvalidBundle
+	}
+	bundle := &Bundle{Flags: buf[1]}
+	buf = buf[2:]
+	next := func() (uint64, bool) {
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return 0, false
+		}
+		buf = buf[n:]
+		return v, true
+	}
+	expiry, ok := next()
+	if !ok {
+		return nil, ErrInvalidBundle
+	}
+	bundle.Expiry = int64(expiry)
+	count, ok := next()
+	// every member takes more than two digests
+	if !ok || count == 0 || count > uint64(len(buf)/(2*cache.TokenDigestSize)) {
+		return nil, ErrInvalidBundle
+	}
+	bundle.Members = make([]BundleMember, count)
+	for i := range bundle.Members {
+		messageID, ok := next()
+		if !ok {
+			return nil, ErrInvalidBundle
+		}
+		linkID, ok := next()
+		if !ok || len(buf) < 2*cache.TokenDigestSize {
+			return nil, ErrInvalidBundle
+		}
+		bundle.Members[i] = BundleMember{
+			MessageID: int(messageID),
+			LinkID:    uint(linkID),
+			Source:    string(buf[:cache.TokenDigestSize]),
+			File:      string(buf[cache.TokenDigestSize : 2*cache.TokenDigestSize]),
+		}
+		buf = buf[2*cache.TokenDigestSize:]
+	}
+	if len(buf) != 0 {
+		return nil, ErrInvalidBundle
+	}
+	return bundle, nil
+}
+
+// BundleToken signs a bundle. The payload names every message and file of
+// the bundle, so the MAC alone binds the token to them.
+func BundleToken(bundle *Bundle) string {
+	payload := bundle.encode()
+	return payload + "." + linkMAC(linkSecret, "bundle", "", payload)
+}
+
+// ParseBundleToken decodes a bundle token and checks its MAC, so forged
+// tokens are turned away before any of their files is fetched.
+func ParseBundleToken(token string) (*Bundle, error) {
+	payload, mac, ok := strings.Cut(token, ".")
+	if !ok || !verifyMAC("bundle", "", payload, mac) {
+		return nil, ErrInvalidBundle
+	}
+	return decodeBundle(payload)
+}
+
+// VerifyBundle checks the expiry of a bundle. Like the links it was made
+// of, a bundle stops working once it, one of its messages or one of those
+// links is revoked, or when one of the links used up its limits.
+func VerifyBundle(token string, bundle *Bundle) error {
+	if bundle.Expiry != 0 && time.Now().Unix() > bundle.Expiry {
+		return ErrLinkExpired
+	}
+	revocations := cache.GetRevocations()
+	for _, member := range bundle.Members {
+		if revocations != nil && (revocations.IsRevoked(member.MessageID, token) || revocations.IsDigestRevoked(member.MessageID, member.Source)) {
+			return ErrLinkRevoked
+		}
+		if err := CheckLinkUsage(member.LinkID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MatchFiles checks that the messages of a bundle still hold the files the
+// bundle was made for.
+func (b *Bundle) MatchFiles(files []*types.File) error {
+	if len(files) != len(b.Members) {
+		return ErrInvalidBundle
+	}
+	for i, member := range b.Members {
+		if subtle.ConstantTimeCompare([]byte(fileDigest(member.MessageID, files[i])), []byte(member.File)) != 1 {
+			return ErrInvalidBundle
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewBundle(t *testing.T) {
+	hashes := []string{"a.1", "b.2", "c.3"}
+	claims := []*LinkClaims{
+		{Expiry: 2000},
+		{Expiry: 1000, LinkID: 5},
+		{Flags: FlagDownloadOnly},
+	}
+	files := []*types.File{testFile(1), testFile(2), testFile(3)}
+	bundle := NewBundle([]int{10, 20, 30}, hashes, claims, files)
+	if bundle.Expiry != 1000 {
+		t.Fatalf("Expiry = %d, want the earliest one", bundle.Expiry)
+	}
+	if bundle.Flags != FlagDownloadOnly {
+		t.Fatalf("Flags = %d, want every flag", bundle.Flags)
+	}
+	want := []BundleMember{
+		{MessageID: 10, Source: cache.TokenDigest("a.1"), File: fileDigest(10, files[0])},
+		{MessageID: 20, LinkID: 5, Source: cache.TokenDigest("b.2"), File: fileDigest(20, files[1])},
+		{MessageID: 30, Source: cache.TokenDigest("c.3"), File: fileDigest(30, files[2])},
+	}
+	if !reflect.DeepEqual(bundle.Members, want) {
+		t.Fatalf("Members = %+v, want %+v", bundle.Members, want)
+	}
+	if got := bundle.Claims(1); *got != (LinkClaims{Expiry: 1000, Flags: FlagDownloadOnly, LinkID: 5}) {
+		t.Fatalf("Claims(1) = %+v", *got)
+	}
+
+	if NewBundle([]int{1}, []string{"a.1"}, []*LinkClaims{{}}, files[:1]).Expiry != 0 {
+		t.Fatal("a bundle of links that never expire must not expire")
+	}
+}
+
+func TestBundleEncoding(t *testing.T) {
+	bundle := &Bundle{
+		Expiry: 1700000000,
+		Flags:  FlagDownloadOnly,
+		Members: []BundleMember{
+			{MessageID: 1, Source: cache.TokenDigest("a"), File: cache.TokenDigest("1")},
+			{MessageID: 1 << 30, LinkID: 99, Source: cache.TokenDigest("b"), File: cache.TokenDigest("2")},
+		},
+	}
+	decoded, err := decodeBundle(bundle.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, bundle) {
+		t.Fatalf("decodeBundle = %+v, want %+v", decoded, bundle)
+	}
+
+	encoded := bundle.encode()
+	for _, payload := range []string{
+		"",
+		"!!",
+		"AQ",
+		// the plain message ID list of the first bundle tokens
+		"MSwyLDM",
+		encoded[:len(encoded)-2],
+		encoded + "AA",
+	} {
+		if _, err := decodeBundle(payload); !errors.Is(err, ErrInvalidBundle) {
+			t.Fatalf("decodeBundle(%q) error = %v, want ErrInvalidBundle", payload, err)
+		}
+	}
+}
+
+func TestParseBundleToken(t *testing.T) {
+	withSecrets(t, "current", "", time.Time{})
+	bundle := &Bundle{Members: []BundleMember{{MessageID: 1, Source: cache.TokenDigest("a.1"), File: cache.TokenDigest("1")}}}
+	token := BundleToken(bundle)
+	forged := &Bundle{Members: []BundleMember{{MessageID: 2, Source: cache.TokenDigest("a.1"), File: cache.TokenDigest("1")}}}
+	mac := strings.SplitN(token, ".", 2)[1]
+
+	parsed, err := ParseBundleToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, bundle) {
+		t.Fatalf("ParseBundleToken = %+v, want %+v", parsed, bundle)
+	}
+	// nothing may be fetched for a token that wasn't signed here, so the
+	// MAC is checked without the files
+	for _, token := range []string{
+		bundle.encode(),
+		bundle.encode() + ".",
+		forged.encode() + "." + mac,
+		token + "x",
+	} {
+		if _, err := ParseBundleToken(token); !errors.Is(err, ErrInvalidBundle) {
+			t.Fatalf("ParseBundleToken(%q) error = %v, want ErrInvalidBundle", token, err)
+		}
+	}
+}
+
+func TestVerifyBundle(t *testing.T) {
+	withSecrets(t, "current", "", time.Time{})
+	members := []BundleMember{
+		{MessageID: 1, Source: cache.TokenDigest("a.1")},
+		{MessageID: 2, Source: cache.TokenDigest("b.2")},
+	}
+
+	valid := &Bundle{Members: members}
+	expiring := &Bundle{Expiry: time.Now().Add(time.Minute).Unix(), Members: members}
+	expired := &Bundle{Expiry: time.Now().Add(-time.Minute).Unix(), Members: members}
+	limited := &Bundle{Members: []BundleMember{members[0], {MessageID: 2, LinkID: 3, Source: members[1].Source}}}
+
+	tests := []struct {
+		name   string
+		bundle *Bundle
+		err    error
+		fails  bool
+	}{
+		{name: "valid", bundle: valid},
+		{name: "not expired", bundle: expiring},
+		{name: "expired", bundle: expired, err: ErrLinkExpired},
+		// link limits can't be checked without the database, which must not let the bundle through
+		{name: "limited", bundle: limited, fails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyBundle(BundleToken(tt.bundle), tt.bundle)
+			if tt.fails {
+				if err == nil {
+					t.Fatal("VerifyBundle accepted the bundle")
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("VerifyBundle error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyBundleRevoked(t *testing.T) {
+	withSecrets(t, "current", "", time.Time{})
+	withStores(t)
+	files := []*types.File{testFile(7), testFile(8)}
+	hashes := []string{SignLink(1, files[0], LinkClaims{}), GetShortHash(FileHash(files[1]))}
+	bundle := NewBundle([]int{1, 2}, hashes, []*LinkClaims{{}, {}}, files)
+	token := BundleToken(bundle)
+
+	tests := []struct {
+		name    string
+		revoke  types.Revocation
+		revoked bool
+	}{
+		{name: "bundle", revoke: types.Revocation{Token: token}, revoked: true},
+		{name: "message", revoke: types.Revocation{MessageID: 2}, revoked: true},
+		{name: "other message", revoke: types.Revocation{MessageID: 3}},
+		{name: "signed link", revoke: types.Revocation{Token: hashes[0]}, revoked: true},
+		{name: "legacy link", revoke: types.Revocation{MessageID: 2, Token: hashes[1]}, revoked: true},
+		// the legacy hash is the same for every copy of the file
+		{name: "legacy link of another copy", revoke: types.Revocation{MessageID: 3, Token: hashes[1]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revocations := cache.GetRevocations()
+			if err := revocations.Revoke(&tt.revoke); err != nil {
+				t.Fatal(err)
+			}
+			defer revocations.Unrevoke(tt.revoke.MessageID, tt.revoke.Token)
+			err := VerifyBundle(token, bundle)
+			if revoked := errors.Is(err, ErrLinkRevoked); revoked != tt.revoked || (!revoked && err != nil) {
+				t.Fatalf("VerifyBundle error = %v, want revoked: %v", err, tt.revoked)
+			}
+		})
+	}
+}
+
+func TestBundleMatchFiles(t *testing.T) {
+	files := []*types.File{testFile(7), testFile(8)}
+	bundle := NewBundle([]int{1, 2}, []string{"a.1", "b.2"}, []*LinkClaims{{}, {}}, files)
+
+	tests := []struct {
+		name  string
+		files []*types.File
+		fails bool
+	}{
+		{name: "same files", files: files},
+		{name: "swapped", files: []*types.File{files[1], files[0]}, fails: true},
+		{name: "replaced", files: []*types.File{files[0], testFile(9)}, fails: true},
+		{name: "missing", files: files[:1], fails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := bundle.MatchFiles(tt.files)
+			if (err != nil) != tt.fails {
+				t.Fatalf("MatchFiles error = %v, want failure: %v", err, tt.fails)
+			}
+		})
+	}
+}
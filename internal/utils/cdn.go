@@ -0,0 +1,376 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/config"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/crypto"
+	"github.com/gotd/td/exchange"
+	"github.com/gotd/td/mtproto"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/transport"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// cdnRetryAfter is how long a CDN DC that failed is skipped before the next
+// attempt to use it.
+const cdnRetryAfter = 10 * time.Minute
+
+// cdnConns keeps one connection to every CDN DC. CDN DCs don't know any
+// user, so the connections are never authorized and every worker shares
+// them. See https://core.telegram.org/cdn.
+type cdnConns struct {
+	mu          sync.Mutex
+	conns       map[int]*tg.Client
+	unreachable map[int]time.Time
+	dials       singleflight.Group
+}
+
+var cdns = &cdnConns{
+	conns:       make(map[int]*tg.Client),
+	unreachable: make(map[int]time.Time),
+}
+
+// available reports whether redirects to the CDN DC should be followed.
+func (c *cdnConns) available(dcID int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	failedAt, ok := c.unreachable[dcID]
+	return !ok || time.Since(failedAt) > cdnRetryAfter
+}
+
+func (c *cdnConns) markUnreachable(dcID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unreachable[dcID] = time.Now()
+}
+
+func (c *cdnConns) lookup(dcID int) (*tg.Client, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	api, ok := c.conns[dcID]
+	return api, ok
+}
+
+// get returns the connection to a CDN DC, dialing it when there is none yet.
+// master is a connection of a worker, which is asked for the address and the
+// keys of the CDN DC.
+func (c *cdnConns) get(ctx context.Context, master *tg.Client, dcID int) (*tg.Client, error) {
+	if api, ok := c.lookup(dcID); ok {
+		return api, nil
+	}
+	res, err, _ := c.dials.Do(strconv.Itoa(dcID), func() (interface{}, error) {
+		if api, ok := c.lookup(dcID); ok {
+			return api, nil
+		}
+		// the dial is shared, so it must not fail when the stream that
+		// started it goes away
+		dialCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dcDialTimeout)
+		defer cancel()
+		api, err := c.dial(dialCtx, master, dcID)
+		if err != nil {
+			return nil, fmt.Errorf("connect to CDN DC %d: %w", dcID, err)
+		}
+		c.mu.Lock()
+		c.conns[dcID] = api
+		c.mu.Unlock()
+		return api, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*tg.Client), nil
+}
+
+func (c *cdnConns) dial(ctx context.Context, master *tg.Client, dcID int) (*tg.Client, error) {
+	cdnConfig, err := master.HelpGetCDNConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := cdnPublicKeys(cdnConfig.PublicKeys, dcID)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := master.HelpGetConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	options := cdnOptions(cfg.DCOptions, dcID)
+	if len(options) == 0 {
+		return nil, errors.New("no address")
+	}
+
+	log := Logger.Named("CDN").With(zap.Int("dcID", dcID))
+	conn := mtproto.New(cdnDialer(options), mtproto.Options{
+		DC:         dcID,
+		PublicKeys: keys,
+		Logger:     log,
+	})
+	ready := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.Run(context.Background(), func(ctx context.Context) error {
+			close(ready)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	select {
+	case <-ready:
+	case err := <-done:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	log.Debug("Opened CDN connection")
+
+	api := tg.NewClient(&cdnInvoker{conn: conn})
+	go func() {
+		err := <-done
+		log.Debug("CDN connection closed", zap.Error(err))
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.conns[dcID] == api {
+			delete(c.conns, dcID)
+		}
+	}()
+	return api, nil
+}
+
+// cdnPublicKeys returns the keys a CDN DC authenticates itself with.
+func cdnPublicKeys(keys []tg.CDNPublicKey, dcID int) ([]exchange.PublicKey, error) {
+	var found []exchange.PublicKey
+	for _, key := range keys {
+		if key.DCID != dcID {
+			continue
+		}
+		block, _ := pem.Decode([]byte(key.PublicKey))
+		if block == nil {
+			return nil, errors.New("invalid public key")
+		}
+		rsaKey, err := crypto.ParseRSA(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, exchange.PublicKey{RSA: rsaKey})
+	}
+	if len(found) == 0 {
+		return nil, errors.New("no public key")
+	}
+	return found, nil
+}
+
+// cdnOptions returns the addresses of a CDN DC that can be reached without
+// an MTProxy secret.
+func cdnOptions(options []tg.DCOption, dcID int) []tg.DCOption {
+	var found []tg.DCOption
+	for _, option := range options {
+		if option.CDN && option.ID == dcID && !option.TCPObfuscatedOnly {
+			found = append(found, option)
+		}
+	}
+	return found
+}
+
+func cdnDialer(options []tg.DCOption) mtproto.Dialer {
+	return func(ctx context.Context) (transport.Conn, error) {
+		var dialer net.Dialer
+		var errs []error
+		for _, option := range options {
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(option.IPAddress, strconv.Itoa(option.Port)))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			transportConn, err := transport.Intermediate.Handshake(conn)
+			if err != nil {
+				conn.Close()
+				errs = append(errs, err)
+				continue
+			}
+			return transportConn, nil
+		}
+		return nil, errors.Join(errs...)
+	}
+}
+
+// cdnInvoker sends requests over a CDN connection. The first request that
+// succeeds carries initConnection, which every new connection has to send.
+type cdnInvoker struct {
+	conn        *mtproto.Conn
+	initialized atomic.Bool
+}
+
+// encoderObject lets a request be the query of initConnection, which is
+// never decoded.
+type encoderObject struct {
+	bin.Encoder
+}
+
+func (encoderObject) Decode(*bin.Buffer) error {
+	return errors.New("request can't be decoded")
+}
+
+func (i *cdnInvoker) Invoke(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+	if i.initialized.Load() {
+		return i.conn.Invoke(ctx, input, output)
+	}
+	var device telegram.DeviceConfig
+	device.SetDefaults()
+	err := i.conn.Invoke(ctx, &tg.InvokeWithLayerRequest{
+		Layer: tg.Layer,
+		Query: &tg.InitConnectionRequest{
+			APIID:          int(config.ValueOf.APIID),
+			DeviceModel:    device.DeviceModel,
+			SystemVersion:  device.SystemVersion,
+			AppVersion:     device.AppVersion,
+			SystemLangCode: device.SystemLangCode,
+			LangPack:       device.LangPack,
+			LangCode:       device.LangCode,
+			Query:          encoderObject{input},
+		},
+	}, output)
+	if err == nil {
+		i.initialized.Store(true)
+	}
+	return err
+}
+
+// cdnFile downloads the parts of one file that Telegram redirected to a CDN.
+// The master DC, where the file lives, hands out the hashes of the parts and
+// uploads them to the CDN when it doesn't have them.
+type cdnFile struct {
+	redirect *tg.UploadFileCDNRedirect
+	master   *tg.Client
+	cdn      *tg.Client
+	mu       sync.Mutex
+	hashes   map[int64]tg.FileHash
+}
+
+func newCDNFile(redirect *tg.UploadFileCDNRedirect, master *tg.Client, cdn *tg.Client) *cdnFile {
+	f := &cdnFile{
+		redirect: redirect,
+		master:   master,
+		cdn:      cdn,
+		hashes:   make(map[int64]tg.FileHash),
+	}
+	f.addHashes(redirect.FileHashes)
+	return f
+}
+
+func (f *cdnFile) addHashes(hashes []tg.FileHash) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, hash := range hashes {
+		f.hashes[hash.Offset] = hash
+	}
+}
+
+func (f *cdnFile) hash(offset int64) (tg.FileHash, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hash, ok := f.hashes[offset]
+	return hash, ok
+}
+
+func (f *cdnFile) chunk(ctx context.Context, offset int64, limit int64) ([]byte, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		res, err := f.cdn.UploadGetCDNFile(ctx, &tg.UploadGetCDNFileRequest{
+			FileToken: f.redirect.FileToken,
+			Offset:    offset,
+			Limit:     int(limit),
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch result := res.(type) {
+		case *tg.UploadCDNFile:
+			data, err := f.decrypt(result.Bytes, offset)
+			if err != nil {
+				return nil, err
+			}
+			if err := f.verify(ctx, data, offset); err != nil {
+				return nil, err
+			}
+			return data, nil
+		case *tg.UploadCDNFileReuploadNeeded:
+			hashes, err := f.master.UploadReuploadCDNFile(ctx, &tg.UploadReuploadCDNFileRequest{
+				FileToken:    f.redirect.FileToken,
+				RequestToken: result.RequestToken,
+			})
+			if err != nil {
+				return nil, err
+			}
+			f.addHashes(hashes)
+		default:
+			return nil, fmt.Errorf("unexpected type %T", result)
+		}
+	}
+	return nil, errors.New("CDN kept asking for the file to be reuploaded")
+}
+
+// decrypt undoes the AES-256-CTR encryption of CDN parts. The IV is the one
+// from the redirect with its last 4 bytes replaced by offset/16.
+func (f *cdnFile) decrypt(src []byte, offset int64) ([]byte, error) {
+	block, err := aes.NewCipher(f.redirect.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.redirect.EncryptionIv) != block.BlockSize() {
+		return nil, fmt.Errorf("invalid CDN IV length %d", len(f.redirect.EncryptionIv))
+	}
+	iv := make([]byte, len(f.redirect.EncryptionIv))
+	copy(iv, f.redirect.EncryptionIv)
+	binary.BigEndian.PutUint32(iv[len(iv)-4:], uint32(offset/16))
+	dst := make([]byte, len(src))
+	cipher.NewCTR(block, iv).XORKeyStream(dst, src)
+	return dst, nil
+}
+
+// verify checks every hashed range of a decrypted part against the SHA-256
+// hashes handed out by the master DC.
+func (f *cdnFile) verify(ctx context.Context, data []byte, offset int64) error {
+	end := offset + int64(len(data))
+	for pos := offset; pos < end; {
+		hash, ok := f.hash(pos)
+		if !ok {
+			hashes, err := f.master.UploadGetCDNFileHashes(ctx, &tg.UploadGetCDNFileHashesRequest{
+				FileToken: f.redirect.FileToken,
+				Offset:    pos,
+			})
+			if err != nil {
+				return err
+			}
+			f.addHashes(hashes)
+			if hash, ok = f.hash(pos); !ok {
+				return fmt.Errorf("no CDN hash for offset %d", pos)
+			}
+		}
+		if hash.Limit <= 0 {
+			return fmt.Errorf("invalid CDN hash at offset %d", pos)
+		}
+		partEnd := min(pos+int64(hash.Limit), end)
+		sum := sha256.Sum256(data[pos-offset : partEnd-offset])
+		if !bytes.Equal(sum[:], hash.Hash) {
+			return fmt.Errorf("CDN hash mismatch at offset %d", pos)
+		}
+		pos = partEnd
+	}
+	return nil
+}
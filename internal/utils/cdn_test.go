@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+func testCDNFile(t *testing.T, hashes []tg.FileHash) *cdnFile {
+	t.Helper()
+	key := bytes.Repeat([]byte{7}, 32)
+	iv := bytes.Repeat([]byte{9}, 16)
+	return newCDNFile(&tg.UploadFileCDNRedirect{EncryptionKey: key, EncryptionIv: iv, FileHashes: hashes}, nil, nil)
+}
+
+func TestCDNDecrypt(t *testing.T) {
+	f := testCDNFile(t, nil)
+	plain := make([]byte, 4096)
+	rand.Read(plain)
+	encrypted, err := f.decrypt(plain, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(encrypted, plain) {
+		t.Fatal("decrypt left the data as it was")
+	}
+
+	// parts at any offset decrypt like the same bytes of the whole file
+	for _, offset := range []int64{0, 16, 1024, 4080} {
+		part, err := f.decrypt(encrypted[offset:], offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(part, plain[offset:]) {
+			t.Fatalf("part at offset %d decrypted wrongly", offset)
+		}
+	}
+
+	f.redirect.EncryptionIv = f.redirect.EncryptionIv[:8]
+	if _, err := f.decrypt(encrypted, 0); err == nil {
+		t.Fatal("decrypt accepted a short IV")
+	}
+}
+
+func TestCDNVerify(t *testing.T) {
+	data := make([]byte, 300)
+	rand.Read(data)
+	hashOf := func(offset, limit int) tg.FileHash {
+		sum := sha256.Sum256(data[offset : offset+limit])
+		return tg.FileHash{Offset: int64(offset), Limit: limit, Hash: sum[:]}
+	}
+	tampered := bytes.Clone(data)
+	tampered[150] ^= 1
+
+	tests := []struct {
+		name   string
+		hashes []tg.FileHash
+		data   []byte
+		offset int64
+		fails  bool
+	}{
+		{name: "whole", hashes: []tg.FileHash{hashOf(0, 100), hashOf(100, 100), hashOf(200, 100)}, data: data},
+		{name: "part", hashes: []tg.FileHash{hashOf(100, 100), hashOf(200, 100)}, data: data[100:], offset: 100},
+		{name: "last hash shorter", hashes: []tg.FileHash{hashOf(0, 256), hashOf(256, 44)}, data: data},
+		{name: "tampered", hashes: []tg.FileHash{hashOf(0, 100), hashOf(100, 100), hashOf(200, 100)}, data: tampered, fails: true},
+		{name: "invalid limit", hashes: []tg.FileHash{{Offset: 0}}, data: data, fails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := testCDNFile(t, tt.hashes)
+			err := f.verify(context.Background(), tt.data, tt.offset)
+			if (err != nil) != tt.fails {
+				t.Fatalf("verify error = %v, want failure: %v", err, tt.fails)
+			}
+		})
+	}
+}
+
+func TestCDNPublicKeys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)}))
+	keys := []tg.CDNPublicKey{{DCID: 201, PublicKey: encoded}, {DCID: 203, PublicKey: "invalid"}}
+
+	found, err := cdnPublicKeys(keys, 201)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || !found[0].RSA.Equal(&key.PublicKey) {
+		t.Fatalf("cdnPublicKeys = %v", found)
+	}
+	for _, dcID := range []int{202, 203} {
+		if _, err := cdnPublicKeys(keys, dcID); err == nil {
+			t.Fatalf("cdnPublicKeys(%d) found a key", dcID)
+		}
+	}
+}
+
+func TestCDNOptions(t *testing.T) {
+	options := []tg.DCOption{
+		{ID: 201, IPAddress: "1.1.1.1", CDN: true},
+		{ID: 201, IPAddress: "2.2.2.2"},
+		{ID: 201, IPAddress: "3.3.3.3", CDN: true, TCPObfuscatedOnly: true},
+		{ID: 202, IPAddress: "4.4.4.4", CDN: true},
+	}
+	found := cdnOptions(options, 201)
+	if len(found) != 1 || found[0].IPAddress != "1.1.1.1" {
+		t.Fatalf("cdnOptions = %v", found)
+	}
+}
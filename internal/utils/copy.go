@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/config"
+	"fmt"
+	"math/rand"
+	"unicode/utf16"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+// maxCaptionLength is the caption limit of bots, in UTF-16 code units like
+// the offsets of message entities.
+const maxCaptionLength = 1024
+
+// CopyMessage sends the media of a message to the log channel again instead
+// of forwarding it, so the copy has no forward header naming the sender and
+// works for chats with protected content. The caption is kept, followed by
+// the ID of the uploader when UPLOADER_CAPTION is set.
+func CopyMessage(ctx *ext.Context, message *tg.Message, uploaderID int64) (*tg.Message, error) {
+	toPeer, err := GetLogChannelPeer(ctx, ctx.Raw, ctx.PeerStorage)
+	if err != nil {
+		return nil, err
+	}
+	media, err := inputMedia(message.Media)
+	if err != nil {
+		return nil, err
+	}
+	caption, entities := copyCaption(message, uploaderID)
+	randomID := rand.Int63()
+	update, err := ctx.Raw.MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
+		Peer:     &tg.InputPeerChannel{ChannelID: toPeer.ChannelID, AccessHash: toPeer.AccessHash},
+		Media:    media,
+		Message:  caption,
+		Entities: entities,
+		RandomID: randomID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return logChannelMessage(ctx, update, randomID)
+}
+
+// inputMedia refers to the document or photo of a message, so it can be
+// sent again without uploading it.
+func inputMedia(media tg.MessageMediaClass) (tg.InputMediaClass, error) {
+	switch media := media.(type) {
+	case *tg.MessageMediaDocument:
+		document, ok := media.Document.AsNotEmpty()
+		if !ok {
+			return nil, fmt.Errorf("unexpected type %T", media)
+		}
+		return &tg.InputMediaDocument{ID: document.AsInput(), Spoiler: media.Spoiler}, nil
+	case *tg.MessageMediaPhoto:
+		photo, ok := media.Photo.AsNotEmpty()
+		if !ok {
+			return nil, fmt.Errorf("unexpected type %T", media)
+		}
+		return &tg.InputMediaPhoto{ID: photo.AsInput(), Spoiler: media.Spoiler}, nil
+	}
+	return nil, fmt.Errorf("unexpected type %T", media)
+}
+
+// copyCaption returns the caption of the copy. The original caption is left
+// out when the uploader line wouldn't fit after it.
+func copyCaption(message *tg.Message, uploaderID int64) (string, []tg.MessageEntityClass) {
+	if !config.ValueOf.UploaderCaption {
+		return message.Message, message.Entities
+	}
+	uploader := fmt.Sprintf("👤 Uploaded by %d", uploaderID)
+	if message.Message == "" {
+		return uploader, nil
+	}
+	caption := message.Message + "\n\n" + uploader
+	if len(utf16.Encode([]rune(caption))) > maxCaptionLength {
+		return uploader, nil
+	}
+	return caption, message.Entities
+}
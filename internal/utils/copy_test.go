@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/config"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestCopyCaption(t *testing.T) {
+	old := *config.ValueOf
+	t.Cleanup(func() { *config.ValueOf = old })
+
+	bold := []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 0, Length: 4}}
+	const uploader = "👤 Uploaded by 42"
+	uploaderLen := len(utf16.Encode([]rune("\n\n" + uploader)))
+	tests := []struct {
+		name            string
+		uploaderCaption bool
+		caption         string
+		want            string
+		keepsEntities   bool
+	}{
+		{name: "unchanged", caption: "Film", want: "Film", keepsEntities: true},
+		{name: "no caption", uploaderCaption: true, want: uploader},
+		{name: "appended", uploaderCaption: true, caption: "Film", want: "Film\n\n" + uploader, keepsEntities: true},
+		{
+			name:            "just fits",
+			uploaderCaption: true,
+			caption:         strings.Repeat("a", maxCaptionLength-uploaderLen),
+			want:            strings.Repeat("a", maxCaptionLength-uploaderLen) + "\n\n" + uploader,
+			keepsEntities:   true,
+		},
+		{name: "too long", uploaderCaption: true, caption: strings.Repeat("a", maxCaptionLength-uploaderLen+1), want: uploader},
+		// emoji take two UTF-16 code units each, counting runes would have let
+		// this caption through
+		{name: "too long in UTF-16", uploaderCaption: true, caption: strings.Repeat("😀", (maxCaptionLength-uploaderLen)/2+1), want: uploader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.ValueOf.UploaderCaption = tt.uploaderCaption
+			message := &tg.Message{Message: tt.caption, Entities: bold}
+			caption, entities := copyCaption(message, 42)
+			if caption != tt.want {
+				t.Fatalf("caption = %q, want %q", caption, tt.want)
+			}
+			if keeps := len(entities) == len(bold); keeps != tt.keepsEntities {
+				t.Fatalf("entities = %v, want kept: %v", entities, tt.keepsEntities)
+			}
+		})
+	}
+}
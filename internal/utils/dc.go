@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/config"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// dcDialTimeout bounds connecting to a DC and transferring the worker's
+// authorization to it.
+const dcDialTimeout = 30 * time.Second
+
+type dcPoolKey struct {
+	clientID int64
+	dcID     int
+}
+
+// dcPools keeps a connection pool for every worker and DC pair. Pools are
+// opened the first time a worker needs a file that lives on another DC and
+// reused by every stream afterwards.
+type dcPools struct {
+	mu    sync.Mutex
+	pools map[dcPoolKey]*tg.Client
+	dials singleflight.Group
+}
+
+var pools = &dcPools{
+	pools: make(map[dcPoolKey]*tg.Client),
+}
+
+// DCClient returns an API client of the given worker that talks to dcID.
+func DCClient(ctx context.Context, client *gotgproto.Client, dcID int) (*tg.Client, error) {
+	return pools.get(ctx, client, dcID)
+}
+
+func (p *dcPools) lookup(key dcPoolKey) (*tg.Client, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	api, ok := p.pools[key]
+	return api, ok
+}
+
+// get returns the pool of a worker and DC, dialing it when there is none
+// yet. Dialing happens outside p.mu, so a slow DC only holds up the streams
+// waiting for that same pool.
+func (p *dcPools) get(ctx context.Context, client *gotgproto.Client, dcID int) (*tg.Client, error) {
+	key := dcPoolKey{clientID: client.Self.ID, dcID: dcID}
+	if api, ok := p.lookup(key); ok {
+		return api, nil
+	}
+	res, err, _ := p.dials.Do(fmt.Sprintf("%d:%d", key.clientID, key.dcID), func() (interface{}, error) {
+		if api, ok := p.lookup(key); ok {
+			return api, nil
+		}
+		// the dial is shared, so it must not fail when the stream that
+		// started it goes away
+		dialCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dcDialTimeout)
+		defer cancel()
+		invoker, err := client.Client.DC(dialCtx, dcID, int64(config.ValueOf.StreamConcurrency))
+		if err != nil {
+			return nil, fmt.Errorf("connect to DC %d: %w", dcID, err)
+		}
+		Logger.Named("DCPool").Debug("Opened DC connection", zap.Int64("clientID", client.Self.ID), zap.Int("dcID", dcID))
+		api := tg.NewClient(invoker)
+		p.mu.Lock()
+		p.pools[key] = api
+		p.mu.Unlock()
+		return api, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*tg.Client), nil
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+// NewFileRecord describes a file forwarded to the log channel for the file
+// index. date is the unix time of the log channel message.
+func NewFileRecord(messageID int, uploaderID int64, date int, file *types.File) (*types.FileRecord, error) {
+	thumbs, err := json.Marshal(file.Thumbs)
+	if err != nil {
+		return nil, err
+	}
+	record := &types.FileRecord{
+		MessageID:   messageID,
+		UploaderID:  uploaderID,
+		DocumentID:  file.ID,
+		Thumbs:      string(thumbs),
+		FileName:    file.FileName,
+		FileSize:    file.FileSize,
+		MimeType:    file.MimeType,
+		Hash:        FileHash(file),
+		MessageDate: time.Unix(int64(date), 0),
+	}
+	switch location := file.Location.(type) {
+	case *tg.InputDocumentFileLocation:
+		record.AccessHash = location.AccessHash
+		record.FileReference = location.FileReference
+	case *tg.InputPhotoFileLocation:
+		record.Photo = true
+		record.AccessHash = location.AccessHash
+		record.FileReference = location.FileReference
+		record.ThumbSize = location.ThumbSize
+	default:
+		return nil, fmt.Errorf("unexpected type %T", file.Location)
+	}
+	return record, nil
+}
+
+// IndexFile adds a file forwarded to the log channel to the file index.
+// Hidden records are left out of the history of the uploader. The index is
+// only a fallback for the log channel, so a failure is logged instead of
+// failing the upload.
+func IndexFile(messageID int, uploaderID int64, date int, file *types.File, hidden bool) {
+	files := cache.GetFiles()
+	if files == nil {
+		return
+	}
+	record, err := NewFileRecord(messageID, uploaderID, date, file)
+	if err == nil {
+		record.Hidden = hidden
+		err = files.Create(record)
+	}
+	if err != nil {
+		Logger.Error("Failed to index file", zap.Int("messageID", messageID), zap.Error(err))
+	}
+}
+
+// FileFromRecord rebuilds the file of an index record, as FileFromMedia
+// would return it.
+func FileFromRecord(record *types.FileRecord) (*types.File, error) {
+	file := &types.File{
+		FileSize: record.FileSize,
+		FileName: record.FileName,
+		MimeType: record.MimeType,
+		ID:       record.DocumentID,
+		Date:     int(record.MessageDate.Unix()),
+	}
+	if record.Thumbs != "" {
+		if err := json.Unmarshal([]byte(record.Thumbs), &file.Thumbs); err != nil {
+			return nil, err
+		}
+	}
+	if record.Photo {
+		file.Location = &tg.InputPhotoFileLocation{
+			ID:            record.DocumentID,
+			AccessHash:    record.AccessHash,
+			FileReference: record.FileReference,
+			ThumbSize:     record.ThumbSize,
+		}
+	} else {
+		file.Location = &tg.InputDocumentFileLocation{
+			ID:            record.DocumentID,
+			AccessHash:    record.AccessHash,
+			FileReference: record.FileReference,
+		}
+	}
+	return file, nil
+}
+
+// fileFromIndex returns the indexed file of a log channel message, or nil
+// when it isn't indexed, was revoked or is known to be deleted.
+func fileFromIndex(messageID int) *types.File {
+	files := cache.GetFiles()
+	if files == nil {
+		return nil
+	}
+	if revocations := cache.GetRevocations(); revocations != nil && revocations.IsRevoked(messageID, "") {
+		return nil
+	}
+	record, err := files.ByMessage(messageID)
+	if err != nil {
+		if !errors.Is(err, cache.ErrFileNotFound) {
+			Logger.Error("Failed to read file index", zap.Int("messageID", messageID), zap.Error(err))
+		}
+		return nil
+	}
+	if record.Gone {
+		return nil
+	}
+	file, err := FileFromRecord(record)
+	if err != nil {
+		Logger.Error("Failed to read indexed file", zap.Int("messageID", messageID), zap.Error(err))
+		return nil
+	}
+	return file
+}
+
+// markIndexedGone keeps the index from standing in for a deleted message.
+func markIndexedGone(messageID int) {
+	files := cache.GetFiles()
+	if files == nil {
+		return
+	}
+	if err := files.MarkGone(messageID); err != nil {
+		Logger.Error("Failed to mark indexed file as deleted", zap.Int("messageID", messageID), zap.Error(err))
+	}
+}
+
+// updateIndexedReference keeps the file reference of the index fresh, so it
+// still works after a restart.
+func updateIndexedReference(messageID int, file *types.File) {
+	files := cache.GetFiles()
+	if files == nil {
+		return
+	}
+	var reference []byte
+	switch location := file.Location.(type) {
+	case *tg.InputDocumentFileLocation:
+		reference = location.FileReference
+	case *tg.InputPhotoFileLocation:
+		reference = location.FileReference
+	default:
+		return
+	}
+	if err := files.UpdateReference(messageID, reference); err != nil {
+		Logger.Error("Failed to update indexed file reference", zap.Int("messageID", messageID), zap.Error(err))
+	}
+}
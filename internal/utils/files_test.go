@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/database"
+	"EverythingSuckz/fsb/internal/types"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// withStores backs the file index, the revocations, the links with limits
+// and the short links with an empty in-memory database.
+func withStores(t *testing.T) *cache.FileStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would get a database of its own
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&types.Revocation{}, &types.FileRecord{}, &types.Link{}, &types.Slug{}); err != nil {
+		t.Fatal(err)
+	}
+	old := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = old })
+	cache.InitFiles(zap.NewNop())
+	cache.InitRevocations(zap.NewNop())
+	cache.InitLinks(zap.NewNop())
+	cache.InitSlugs(zap.NewNop())
+	return cache.GetFiles()
+}
+
+func TestFileFromRecord(t *testing.T) {
+	file := testFile(7)
+	file.Date = 1700000000
+	file.Thumbs = []types.Thumb{{Type: "m", Size: 100}}
+	record, err := NewFileRecord(10, 1, file.Date, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := FileFromRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != file.ID || got.FileSize != file.FileSize || got.FileName != file.FileName || got.Date != file.Date || len(got.Thumbs) != 1 {
+		t.Fatalf("FileFromRecord = %+v, want %+v", got, file)
+	}
+	if FileHash(got) != FileHash(file) {
+		t.Fatal("the indexed file hashes differently")
+	}
+}
+
+func TestFileFromIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		index   bool
+		gone    bool
+		revoked bool
+		found   bool
+	}{
+		{name: "indexed", index: true, found: true},
+		{name: "not indexed"},
+		{name: "deleted message", index: true, gone: true},
+		{name: "revoked message", index: true, revoked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := withStores(t)
+			if tt.index {
+				IndexFile(10, 1, 1700000000, testFile(7), false)
+			}
+			if tt.gone {
+				markIndexedGone(10)
+			}
+			if tt.revoked {
+				revocations := cache.GetRevocations()
+				if err := revocations.Revoke(&types.Revocation{MessageID: 10}); err != nil {
+					t.Fatal(err)
+				}
+				// the store outlives the test
+				t.Cleanup(func() { revocations.Unrevoke(10, "") })
+			}
+			IndexFile(11, 1, 1700000000, testFile(8), false)
+
+			file := fileFromIndex(10)
+			if (file != nil) != tt.found {
+				t.Fatalf("fileFromIndex = %+v, want found: %v", file, tt.found)
+			}
+			if file != nil && file.ID != 7 {
+				t.Fatalf("fileFromIndex returned file %d", file.ID)
+			}
+			if fileFromIndex(11) == nil {
+				t.Fatal("another message was dropped from the index")
+			}
+			if _, err := files.ByMessage(10); tt.index && err != nil {
+				t.Fatal("the history lost the record of a deleted message")
+			}
+		})
+	}
+}
@@ -9,6 +9,16 @@ func PackFile(fileName string, fileSize int64, mimeType string, fileID int64) st
 	return (&types.HashableFileStruct{FileName: fileName, FileSize: fileSize, MimeType: mimeType, FileID: fileID}).Pack()
 }
 
+// FileHash returns the full link hash of a file. Photos are hashed with a zero
+// size, as their size depends on the thumb type being served.
+func FileHash(file *types.File) string {
+	size := file.FileSize
+	if file.IsPhoto() {
+		size = 0
+	}
+	return PackFile(file.FileName, size, file.MimeType, file.ID)
+}
+
 func GetShortHash(fullHash string) string {
 	return fullHash[:config.ValueOf.HashLength]
 }
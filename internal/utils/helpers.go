@@ -14,8 +14,12 @@ import (
 	"github.com/celestix/gotgproto/storage"
 	"github.com/gotd/td/tg"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
+// ErrFileDeleted is returned for log channel messages that no longer exist.
+var ErrFileDeleted = errors.New("This File was Deleted, either by an admin or after 24 hours had passed. For more updates, join @haris_garage ")
+
 // https://stackoverflow.com/a/70802740/15807350
 func Contains[T comparable](s []T, e T) bool {
 	for _, v := range s {
@@ -27,22 +31,29 @@ func Contains[T comparable](s []T, e T) bool {
 }
 
 func GetTGMessage(ctx context.Context, client *gotgproto.Client, messageID int) (*tg.Message, error) {
+	return getLogMessage(ctx, client.API(), client.PeerStorage, messageID)
+}
+
+func getLogMessage(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, messageID int) (*tg.Message, error) {
 	inputMessageID := tg.InputMessageClass(&tg.InputMessageID{ID: messageID})
-	channel, err := GetLogChannelPeer(ctx, client.API(), client.PeerStorage)
+	channel, err := GetLogChannelPeer(ctx, api, peerStorage)
 	if err != nil {
 		return nil, err
 	}
 	messageRequest := tg.ChannelsGetMessagesRequest{Channel: channel, ID: []tg.InputMessageClass{inputMessageID}}
-	res, err := client.API().ChannelsGetMessages(ctx, &messageRequest)
+	res, err := api.ChannelsGetMessages(ctx, &messageRequest)
 	if err != nil {
 		return nil, err
 	}
-	messages := res.(*tg.MessagesChannelMessages)
+	messages, ok := res.(*tg.MessagesChannelMessages)
+	if !ok || len(messages.Messages) == 0 {
+		return nil, fmt.Errorf("unexpected result %T", res)
+	}
 	message := messages.Messages[0]
 	if _, ok := message.(*tg.Message); ok {
 		return message.(*tg.Message), nil
 	} else {
-		return nil, fmt.Errorf("This File was Deleted, either by an admin or after 24 hours had passed. For more updates, join @haris_garage ")
+		return nil, ErrFileDeleted
 	}
 }
 
@@ -66,48 +77,177 @@ func FileFromMedia(media tg.MessageMediaClass) (*types.File, error) {
 			FileName: fileName,
 			MimeType: document.MimeType,
 			ID:       document.ID,
+			Thumbs:   photoThumbs(document.Thumbs),
 		}, nil
 	case *tg.MessageMediaPhoto:
 		photo, ok := media.Photo.AsNotEmpty()
 		if !ok {
 			return nil, fmt.Errorf("unexpected type %T", media)
 		}
-		sizes := photo.Sizes
-		if len(sizes) == 0 {
+		thumbs := photoThumbs(photo.Sizes)
+		if len(thumbs) == 0 {
 			return nil, errors.New("photo has no sizes")
 		}
-		photoSize := sizes[len(sizes)-1]
-		size, ok := photoSize.AsNotEmpty()
-		if !ok {
-			return nil, errors.New("photo size is empty")
-		}
+		largest := thumbs[len(thumbs)-1]
 		location := new(tg.InputPhotoFileLocation)
 		location.ID = photo.GetID()
 		location.AccessHash = photo.GetAccessHash()
 		location.FileReference = photo.GetFileReference()
-		location.ThumbSize = size.GetType()
+		location.ThumbSize = largest.Type
 		return &types.File{
 			Location: location,
-			FileSize: 0, // caller should judge if this is a photo or not
+			FileSize: largest.Size,
 			FileName: fmt.Sprintf("photo_%d.jpg", photo.GetID()),
 			MimeType: "image/jpeg",
 			ID:       photo.GetID(),
+			Thumbs:   thumbs,
 		}, nil
 	}
 	return nil, fmt.Errorf("unexpected type %T", media)
 }
 
+// photoThumbs lists the sizes that can be downloaded with upload.getFile,
+// leaving out the stripped and vector previews.
+func photoThumbs(sizes []tg.PhotoSizeClass) []types.Thumb {
+	thumbs := make([]types.Thumb, 0, len(sizes))
+	for _, size := range sizes {
+		switch size := size.(type) {
+		case *tg.PhotoSize:
+			thumbs = append(thumbs, types.Thumb{Type: size.Type, Size: int64(size.Size)})
+		case *tg.PhotoCachedSize:
+			thumbs = append(thumbs, types.Thumb{Type: size.Type, Size: int64(len(size.Bytes))})
+		case *tg.PhotoSizeProgressive:
+			if len(size.Sizes) == 0 {
+				continue
+			}
+			thumbs = append(thumbs, types.Thumb{Type: size.Type, Size: int64(size.Sizes[len(size.Sizes)-1])})
+		}
+	}
+	return thumbs
+}
+
+// FileWithThumb returns a copy of file that points at the given size type
+// instead of the default one. For documents that is one of their thumbnails,
+// which are always JPEG images.
+func FileWithThumb(file *types.File, thumbType string) (*types.File, error) {
+	var thumb *types.Thumb
+	for i := range file.Thumbs {
+		if file.Thumbs[i].Type == thumbType {
+			thumb = &file.Thumbs[i]
+			break
+		}
+	}
+	if thumb == nil {
+		return nil, fmt.Errorf("size %q is not available", thumbType)
+	}
+	sized := *file
+	sized.FileSize = thumb.Size
+	switch location := file.Location.(type) {
+	case *tg.InputPhotoFileLocation:
+		l := *location
+		l.ThumbSize = thumb.Type
+		sized.Location = &l
+	case *tg.InputDocumentFileLocation:
+		l := *location
+		l.ThumbSize = thumb.Type
+		sized.Location = &l
+		sized.FileName = fmt.Sprintf("thumb_%d_%s.jpg", file.ID, thumb.Type)
+		sized.MimeType = "image/jpeg"
+	default:
+		return nil, fmt.Errorf("unexpected type %T", file.Location)
+	}
+	return &sized, nil
+}
+
+// indexedFileTTL is how long the file properties read from the index are
+// cached before Telegram confirms them, in seconds.
+const indexedFileTTL = 60
+
+// indexChecks asks Telegram about the messages served from the index, once
+// per message and client at a time.
+var indexChecks singleflight.Group
+
+func fileCacheKey(messageID int, clientID int64) string {
+	return fmt.Sprintf("file:%d:%d", messageID, clientID)
+}
+
 func FileFromMessage(ctx context.Context, client *gotgproto.Client, messageID int) (*types.File, error) {
-	key := fmt.Sprintf("file:%d:%d", messageID, client.Self.ID)
+	return fileFromMessage(ctx, client.API(), client.PeerStorage, client.Self.ID, messageID)
+}
+
+// FileFromContext fetches the file of a log channel message from within an
+// update handler, using the bot that received the update.
+func FileFromContext(ctx *ext.Context, messageID int) (*types.File, error) {
+	return fileFromMessage(ctx, ctx.Raw, ctx.PeerStorage, ctx.Self.ID, messageID)
+}
+
+// FreshFileFromContext fetches the file of a log channel message from
+// Telegram, skipping the cache and the file index, which also tells whether
+// the message still exists.
+func FreshFileFromContext(ctx *ext.Context, messageID int) (*types.File, error) {
+	file, err := fetchFile(ctx, ctx.Raw, ctx.PeerStorage, ctx.Self.ID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	updateIndexedReference(messageID, file)
+	return file, nil
+}
+
+// fileFromMessage returns the cached file properties of a message, or asks
+// Telegram for them. Indexed messages are served from the index after a
+// restart while Telegram is asked in the background, so a deleted message is
+// only served until that check ends, and a failed check again after
+// indexedFileTTL.
+func fileFromMessage(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, clientID int64, messageID int) (*types.File, error) {
+	key := fileCacheKey(messageID, clientID)
 	log := Logger.Named("GetMessageMedia")
 	var cachedMedia types.File
 	err := cache.GetCache().Get(key, &cachedMedia)
 	if err == nil {
-		log.Debug("Using cached media message properties", zap.Int("messageID", messageID), zap.Int64("clientID", client.Self.ID))
+		log.Debug("Using cached media message properties", zap.Int("messageID", messageID), zap.Int64("clientID", clientID))
 		return &cachedMedia, nil
 	}
-	log.Debug("Fetching file properties from message ID", zap.Int("messageID", messageID), zap.Int64("clientID", client.Self.ID))
-	message, err := GetTGMessage(ctx, client, messageID)
+	if file := fileFromIndex(messageID); file != nil {
+		log.Debug("Using indexed file properties", zap.Int("messageID", messageID), zap.Int64("clientID", clientID))
+		if err := cache.GetCache().Set(key, file, indexedFileTTL); err != nil {
+			log.Error("Failed to cache file properties", zap.Int("messageID", messageID), zap.Error(err))
+		}
+		checkIndexedFile(ctx, api, peerStorage, clientID, messageID)
+		return file, nil
+	}
+	return fetchFile(ctx, api, peerStorage, clientID, messageID)
+}
+
+// checkIndexedFile asks Telegram for a message that was served from the
+// index. Its answer replaces the cached properties, or drops them when the
+// message was deleted.
+func checkIndexedFile(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, clientID int64, messageID int) {
+	key := fileCacheKey(messageID, clientID)
+	indexChecks.DoChan(key, func() (interface{}, error) {
+		// the check outlives the request that started it
+		checkCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dcDialTimeout)
+		defer cancel()
+		file, err := fetchFile(checkCtx, api, peerStorage, clientID, messageID)
+		switch {
+		case errors.Is(err, ErrFileDeleted):
+			cache.GetCache().Delete(key)
+		case err != nil:
+			Logger.Named("GetMessageMedia").Warn("Failed to check indexed file", zap.Int("messageID", messageID), zap.Error(err))
+		default:
+			updateIndexedReference(messageID, file)
+		}
+		return nil, err
+	})
+}
+
+// fetchFile reads the file properties from the log channel message and
+// caches them.
+func fetchFile(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, clientID int64, messageID int) (*types.File, error) {
+	Logger.Named("GetMessageMedia").Debug("Fetching file properties from message ID", zap.Int("messageID", messageID), zap.Int64("clientID", clientID))
+	message, err := getLogMessage(ctx, api, peerStorage, messageID)
+	if errors.Is(err, ErrFileDeleted) {
+		markIndexedGone(messageID)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -115,14 +255,28 @@ func FileFromMessage(ctx context.Context, client *gotgproto.Client, messageID in
 	if err != nil {
 		return nil, err
 	}
+	file.Date = message.Date
 	err = cache.GetCache().Set(
-		key,
+		fileCacheKey(messageID, clientID),
 		file,
 		3600,
 	)
+	if err != nil {
+		Logger.Named("GetMessageMedia").Error("Failed to cache file properties", zap.Int("messageID", messageID), zap.Error(err))
+	}
+	return file, nil
+}
+
+// RefreshFile drops the cached file properties of a message and fetches them
+// again, which gives a fresh file reference once Telegram expired the old one.
+func RefreshFile(ctx context.Context, client *gotgproto.Client, messageID int) (*types.File, error) {
+	Logger.Named("RefreshFile").Debug("Refreshing file reference", zap.Int("messageID", messageID), zap.Int64("clientID", client.Self.ID))
+	cache.GetCache().Delete(fileCacheKey(messageID, client.Self.ID))
+	file, err := fetchFile(ctx, client.API(), client.PeerStorage, client.Self.ID, messageID)
 	if err != nil {
 		return nil, err
 	}
+	updateIndexedReference(messageID, file)
 	return file, nil
 }
 
@@ -159,7 +313,9 @@ func GetLogChannelPeer(ctx context.Context, api *tg.Client, peerStorage *storage
 	return channel.AsInput(), nil
 }
 
-func ForwardMessages(ctx *ext.Context, fromChatId, toChatId int64, messageID int) (*tg.Updates, error) {
+// ForwardMessages forwards a message to the log channel and returns the
+// forwarded message.
+func ForwardMessages(ctx *ext.Context, fromChatId, toChatId int64, messageID int) (*tg.Message, error) {
 	fromPeer := ctx.PeerStorage.GetInputPeerById(fromChatId)
 	if fromPeer.Zero() {
 		return nil, fmt.Errorf("fromChatId: %d is not a valid peer", fromChatId)
@@ -168,8 +324,9 @@ func ForwardMessages(ctx *ext.Context, fromChatId, toChatId int64, messageID int
 	if err != nil {
 		return nil, err
 	}
+	randomID := rand.Int63()
 	update, err := ctx.Raw.MessagesForwardMessages(ctx, &tg.MessagesForwardMessagesRequest{
-		RandomID: []int64{rand.Int63()},
+		RandomID: []int64{randomID},
 		FromPeer: fromPeer,
 		ID:       []int{messageID},
 		ToPeer:   &tg.InputPeerChannel{ChannelID: toPeer.ChannelID, AccessHash: toPeer.AccessHash},
@@ -177,7 +334,7 @@ func ForwardMessages(ctx *ext.Context, fromChatId, toChatId int64, messageID int
 	if err != nil {
 		return nil, err
 	}
-	return update.(*tg.Updates), nil
+	return logChannelMessage(ctx, update, randomID)
 }
 
 func IsUserSubscribed(ctx context.Context, client *tg.Client, peerStorage *storage.PeerStorage, userID int64) (bool, error) {
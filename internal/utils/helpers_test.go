@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/internal/types"
+	"slices"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestPhotoThumbs(t *testing.T) {
+	sizes := []tg.PhotoSizeClass{
+		&tg.PhotoStrippedSize{Type: "i", Bytes: []byte{1, 2}},
+		&tg.PhotoSize{Type: "m", Size: 100},
+		&tg.PhotoCachedSize{Type: "s", Bytes: make([]byte, 50)},
+		&tg.PhotoSizeProgressive{Type: "y", Sizes: []int{300, 600, 900}},
+		&tg.PhotoSizeProgressive{Type: "w"},
+		&tg.PhotoPathSize{Type: "j", Bytes: []byte{3}},
+	}
+	want := []types.Thumb{{Type: "m", Size: 100}, {Type: "s", Size: 50}, {Type: "y", Size: 900}}
+	if thumbs := photoThumbs(sizes); !slices.Equal(thumbs, want) {
+		t.Fatalf("photoThumbs = %v, want %v", thumbs, want)
+	}
+}
+
+func TestFileFromMedia(t *testing.T) {
+	tests := []struct {
+		name     string
+		media    tg.MessageMediaClass
+		fileName string
+		mimeType string
+		size     int64
+		thumb    string // size type of the location
+		err      bool
+	}{
+		{
+			name: "document",
+			media: &tg.MessageMediaDocument{Document: &tg.Document{
+				ID:         7,
+				Size:       1024,
+				MimeType:   "video/mp4",
+				Attributes: []tg.DocumentAttributeClass{&tg.DocumentAttributeVideo{}, &tg.DocumentAttributeFilename{FileName: "video.mp4"}},
+				Thumbs:     []tg.PhotoSizeClass{&tg.PhotoSize{Type: "m", Size: 100}},
+			}},
+			fileName: "video.mp4",
+			mimeType: "video/mp4",
+			size:     1024,
+		},
+		{
+			name: "photo",
+			media: &tg.MessageMediaPhoto{Photo: &tg.Photo{
+				ID:    7,
+				Sizes: []tg.PhotoSizeClass{&tg.PhotoStrippedSize{Type: "i"}, &tg.PhotoSize{Type: "m", Size: 100}, &tg.PhotoSize{Type: "y", Size: 900}},
+			}},
+			fileName: "photo_7.jpg",
+			mimeType: "image/jpeg",
+			size:     900,
+			thumb:    "y",
+		},
+		{name: "photo without sizes", media: &tg.MessageMediaPhoto{Photo: &tg.Photo{ID: 7}}, err: true},
+		{name: "empty photo", media: &tg.MessageMediaPhoto{Photo: &tg.PhotoEmpty{ID: 7}}, err: true},
+		{name: "empty document", media: &tg.MessageMediaDocument{Document: &tg.DocumentEmpty{ID: 7}}, err: true},
+		{name: "no media", media: &tg.MessageMediaEmpty{}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := FileFromMedia(tt.media)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("FileFromMedia = %+v, want an error", file)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if file.ID != 7 || file.FileName != tt.fileName || file.MimeType != tt.mimeType || file.FileSize != tt.size {
+				t.Fatalf("FileFromMedia = %+v", file)
+			}
+			if thumb := file.ThumbType(); thumb != tt.thumb {
+				t.Fatalf("location points at size %q, want %q", thumb, tt.thumb)
+			}
+		})
+	}
+}
+
+func TestFileWithThumb(t *testing.T) {
+	document := testFile(7)
+	document.Thumbs = []types.Thumb{{Type: "m", Size: 100}}
+	photo := &types.File{
+		Location: &tg.InputPhotoFileLocation{ID: 8, ThumbSize: "y"},
+		FileSize: 900,
+		FileName: "photo_8.jpg",
+		MimeType: "image/jpeg",
+		ID:       8,
+		Thumbs:   []types.Thumb{{Type: "m", Size: 100}, {Type: "y", Size: 900}},
+	}
+
+	tests := []struct {
+		name      string
+		file      *types.File
+		thumbType string
+		fileName  string
+		mimeType  string
+		size      int64
+		err       bool
+	}{
+		{name: "document thumbnail", file: document, thumbType: "m", fileName: "thumb_7_m.jpg", mimeType: "image/jpeg", size: 100},
+		{name: "photo size", file: photo, thumbType: "m", fileName: "photo_8.jpg", mimeType: "image/jpeg", size: 100},
+		{name: "unknown size", file: document, thumbType: "x", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := tt.file.ThumbType()
+			sized, err := FileWithThumb(tt.file, tt.thumbType)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("FileWithThumb = %+v, want an error", sized)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sized.ThumbType() != tt.thumbType || sized.FileName != tt.fileName || sized.MimeType != tt.mimeType || sized.FileSize != tt.size {
+				t.Fatalf("FileWithThumb = %+v", sized)
+			}
+			if tt.file.ThumbType() != before {
+				t.Fatal("FileWithThumb changed the location of the file")
+			}
+		})
+	}
+}
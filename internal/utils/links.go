@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
+	"errors"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type LinkKind string
+
+const (
+	LinkStream   LinkKind = "stream"
+	LinkDownload LinkKind = "download"
+	LinkWatch    LinkKind = "watch"
+	LinkProxy    LinkKind = "proxy"
+)
+
+// Link is a ready to send URL of one of the enabled link kinds.
+type Link struct {
+	Kind  LinkKind `json:"kind"`
+	Label string   `json:"-"`
+	URL   string   `json:"url"`
+}
+
+// linkKindIDs go into the claims of each kind, so the links of a file
+// don't share a token. Stream links keep the tokens of the links that were
+// sent before there were kinds.
+var linkKindIDs = map[LinkKind]uint8{
+	LinkStream:   0,
+	LinkDownload: 1,
+	LinkWatch:    2,
+	LinkProxy:    3,
+}
+
+var linkLabels = map[LinkKind]string{
+	LinkStream:   "▶️ Stream",
+	LinkDownload: "⬇️ Download",
+	LinkWatch:    "🖥 Watch",
+	LinkProxy:    "Streaming / Download",
+}
+
+func linkTemplate(kind LinkKind) string {
+	switch kind {
+	case LinkStream:
+		return config.ValueOf.StreamLinkTemplate
+	case LinkDownload:
+		return config.ValueOf.DownloadLinkTemplate
+	case LinkWatch:
+		return config.ValueOf.WatchLinkTemplate
+	case LinkProxy:
+		return config.ValueOf.ProxyLinkTemplate
+	}
+	return ""
+}
+
+// FormatLink fills the {host}, {id}, {hash} and {filename} placeholders of a
+// link template. The filename is query escaped.
+func FormatLink(template string, messageID int, hash string, fileName string) string {
+	return strings.NewReplacer(
+		"{host}", config.ValueOf.Host,
+		"{id}", strconv.Itoa(messageID),
+		"{hash}", url.QueryEscape(hash),
+		"{filename}", url.QueryEscape(fileName),
+	).Replace(template)
+}
+
+// FileLinks returns signed links of every enabled kind in the order they
+// are listed in LINK_KINDS. Kinds without a template are skipped, and
+// download links are signed as download only. Every kind gets a token of its
+// own, so revoking one link leaves the others working.
+func FileLinks(messageID int, file *types.File, claims LinkClaims) []Link {
+	var links []Link
+	for _, name := range config.ValueOf.LinkKinds {
+		kind := LinkKind(name)
+		template := linkTemplate(kind)
+		if template == "" {
+			continue
+		}
+		kindClaims := claims
+		kindClaims.Kind = linkKindIDs[kind]
+		if kind == LinkDownload {
+			kindClaims.Flags |= FlagDownloadOnly
+		}
+		hash := SignLink(messageID, file, kindClaims)
+		links = append(links, Link{
+			Kind:  kind,
+			Label: linkLabels[kind],
+			URL:   FormatLink(template, messageID, hash, file.FileName),
+		})
+	}
+	return links
+}
+
+// NewLimitedLink stores a link to a message that stops working after
+// maxDownloads complete downloads or maxBytes served, a limit of 0 meaning no
+// limit. It returns the claims its links have to be signed with.
+func NewLimitedLink(messageID int, createdBy int64, maxDownloads int64, maxBytes int64) (LinkClaims, *types.Link, error) {
+	claims := DefaultLinkClaims()
+	links := cache.GetLinks()
+	if links == nil {
+		return claims, nil, errors.New("link limits are not available")
+	}
+	if maxDownloads < 0 || maxBytes < 0 {
+		return claims, nil, errors.New("limits can't be negative")
+	}
+	if maxDownloads == 0 && maxBytes == 0 {
+		return claims, nil, errors.New("at least one limit is required")
+	}
+	link := &types.Link{
+		MessageID:    messageID,
+		MaxDownloads: maxDownloads,
+		MaxBytes:     maxBytes,
+		CreatedBy:    createdBy,
+	}
+	if err := links.Create(link); err != nil {
+		return claims, nil, err
+	}
+	claims.LinkID = link.ID
+	return claims, link, nil
+}
+
+// ParseLinkTarget reads what a link points at from a message ID, a link sent
+// by the bot or a bare link hash. Links and hashes return their token, so a
+// single link can be told apart from every link of the message.
+func ParseLinkTarget(target string) (int, string, error) {
+	target = strings.TrimSpace(target)
+	if messageID, err := strconv.Atoi(target); err == nil {
+		return messageID, "", nil
+	}
+	if !strings.Contains(target, "://") {
+		if target == "" || strings.ContainsAny(target, "/?&= ") {
+			return 0, "", errors.New("not a message ID, link or hash")
+		}
+		return 0, target, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return 0, "", err
+	}
+	// short links stand for the link they were created from
+	if name, ok := strings.CutPrefix(u.Path, "/s/"); ok {
+		slugs := cache.GetSlugs()
+		if slugs == nil {
+			return 0, "", errors.New("short links are not available")
+		}
+		slug, err := slugs.Get(strings.ToLower(name))
+		if err != nil {
+			return 0, "", err
+		}
+		return slug.MessageID, slug.Token, nil
+	}
+	query := u.Query()
+	// proxy links carry the stream path in a parameter, eg. ?video=<id>?hash=<hash>
+	if video := query.Get("video"); video != "" {
+		id, rawQuery, _ := strings.Cut(video, "?")
+		videoQuery, _ := url.ParseQuery(rawQuery)
+		messageID, _ := strconv.Atoi(id)
+		if hash := videoQuery.Get("hash"); hash != "" {
+			return messageID, hash, nil
+		}
+	}
+	if token := query.Get("bundle"); token != "" {
+		return 0, token, nil
+	}
+	hash := query.Get("hash")
+	if hash == "" {
+		return 0, "", errors.New("link has no hash")
+	}
+	messageID, _ := strconv.Atoi(path.Base(u.Path))
+	return messageID, hash, nil
+}
@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestFileLinks(t *testing.T) {
+	withSecrets(t, "current", "", time.Time{})
+	old := *config.ValueOf
+	t.Cleanup(func() { *config.ValueOf = old })
+	config.ValueOf.Host = "https://example.com"
+	config.ValueOf.LinkKinds = []string{"stream", "download", "watch"}
+	config.ValueOf.StreamLinkTemplate = "{host}/stream/{id}?hash={hash}"
+	config.ValueOf.DownloadLinkTemplate = "{host}/stream/{id}?hash={hash}&d=true"
+	config.ValueOf.WatchLinkTemplate = "{host}/watch/{id}?hash={hash}"
+
+	file := testFile(7)
+	links := FileLinks(10, file, LinkClaims{})
+	if len(links) != 3 {
+		t.Fatalf("FileLinks returned %d links, want 3", len(links))
+	}
+	seen := make(map[string]LinkKind)
+	for _, link := range links {
+		u, err := url.Parse(link.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash := u.Query().Get("hash")
+		// revoking one kind must leave the others working
+		if kind, ok := seen[hash]; ok {
+			t.Fatalf("%s and %s links share a token", kind, link.Kind)
+		}
+		seen[hash] = link.Kind
+		claims, err := VerifyLink(hash, 10, file)
+		if err != nil {
+			t.Fatalf("%s link: %v", link.Kind, err)
+		}
+		if claims.DownloadOnly() != (link.Kind == LinkDownload) {
+			t.Fatalf("%s link download only = %v", link.Kind, claims.DownloadOnly())
+		}
+	}
+
+	// stream links keep the tokens signed before links had kinds
+	if hash := SignLink(10, file, LinkClaims{}); seen[hash] != LinkStream {
+		t.Fatal("stream links got a new token")
+	}
+}
+
+func TestNewLimitedLink(t *testing.T) {
+	withSecrets(t, "current", "", time.Time{})
+	withStores(t)
+	tests := []struct {
+		name         string
+		maxDownloads int64
+		maxBytes     int64
+		fails        bool
+	}{
+		{name: "downloads", maxDownloads: 3},
+		{name: "bytes", maxBytes: 1 << 30},
+		{name: "both", maxDownloads: 3, maxBytes: 1 << 30},
+		{name: "no limit", fails: true},
+		{name: "negative", maxDownloads: -1, maxBytes: 10, fails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, link, err := NewLimitedLink(10, 1, tt.maxDownloads, tt.maxBytes)
+			if tt.fails {
+				if err == nil {
+					t.Fatalf("NewLimitedLink = %+v, want an error", link)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.LinkID == 0 || claims.LinkID != link.ID {
+				t.Fatalf("claims.LinkID = %d, link.ID = %d", claims.LinkID, link.ID)
+			}
+			if link.MessageID != 10 || link.MaxDownloads != tt.maxDownloads || link.MaxBytes != tt.maxBytes {
+				t.Fatalf("link = %+v", link)
+			}
+			if err := CheckLinkUsage(claims.LinkID); err != nil {
+				t.Fatalf("CheckLinkUsage of a new link = %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckLinkUsage(t *testing.T) {
+	withStores(t)
+	links := cache.GetLinks()
+	tests := []struct {
+		name      string
+		link      *types.Link
+		downloads int
+		bytes     int64
+		err       error
+	}{
+		{name: "unused", link: &types.Link{MaxDownloads: 2}},
+		{name: "downloads left", link: &types.Link{MaxDownloads: 2}, downloads: 1},
+		{name: "downloads used up", link: &types.Link{MaxDownloads: 2}, downloads: 2, err: ErrLinkUsedUp},
+		{name: "bytes left", link: &types.Link{MaxBytes: 100}, bytes: 99},
+		{name: "bytes used up", link: &types.Link{MaxBytes: 100}, bytes: 100, err: ErrLinkUsedUp},
+		{name: "either limit", link: &types.Link{MaxDownloads: 5, MaxBytes: 100}, downloads: 1, bytes: 150, err: ErrLinkUsedUp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.link.MessageID = 10
+			if err := links.Create(tt.link); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.downloads; i++ {
+				if _, err := links.AddDownload(tt.link.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.bytes > 0 {
+				if _, err := links.AddBytes(tt.link.ID, tt.bytes); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := CheckLinkUsage(tt.link.ID); !errors.Is(err, tt.err) {
+				t.Fatalf("CheckLinkUsage error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	if err := CheckLinkUsage(0); err != nil {
+		t.Fatalf("CheckLinkUsage of a link without limits = %v", err)
+	}
+	if err := CheckLinkUsage(9999); !errors.Is(err, ErrInvalidLink) {
+		t.Fatalf("CheckLinkUsage of an unknown link error = %v, want ErrInvalidLink", err)
+	}
+}
+
+func TestFormatLink(t *testing.T) {
+	old := *config.ValueOf
+	t.Cleanup(func() { *config.ValueOf = old })
+	config.ValueOf.Host = "https://example.com"
+
+	tests := []struct {
+		name     string
+		template string
+		hash     string
+		fileName string
+		want     string
+	}{
+		{name: "stream", template: "{host}/stream/{id}?hash={hash}", hash: "abc", want: "https://example.com/stream/10?hash=abc"},
+		{name: "file name", template: "{host}/stream/{id}/{filename}?hash={hash}", hash: "abc", fileName: "my video&.mp4", want: "https://example.com/stream/10/my+video%26.mp4?hash=abc"},
+		{name: "escaped hash", template: "{host}/stream/{id}?hash={hash}", hash: "a+b/c=", want: "https://example.com/stream/10?hash=a%2Bb%2Fc%3D"},
+		{name: "placeholder twice", template: "https://player.example/?video={host}/stream/{id}?hash={hash}&id={id}", hash: "abc", want: "https://player.example/?video=https://example.com/stream/10?hash=abc&id=10"},
+		{name: "no placeholders", template: "https://example.org", want: "https://example.org"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if link := FormatLink(tt.template, 10, tt.hash, tt.fileName); link != tt.want {
+				t.Fatalf("FormatLink = %s, want %s", link, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkTemplate(t *testing.T) {
+	old := *config.ValueOf
+	t.Cleanup(func() { *config.ValueOf = old })
+	config.ValueOf.StreamLinkTemplate = "stream"
+	config.ValueOf.DownloadLinkTemplate = "download"
+	config.ValueOf.WatchLinkTemplate = "watch"
+	config.ValueOf.ProxyLinkTemplate = ""
+
+	tests := []struct {
+		kind     LinkKind
+		template string
+	}{
+		{kind: LinkStream, template: "stream"},
+		{kind: LinkDownload, template: "download"},
+		{kind: LinkWatch, template: "watch"},
+		{kind: LinkProxy},
+		{kind: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.kind), func(t *testing.T) {
+			if template := linkTemplate(tt.kind); template != tt.template {
+				t.Fatalf("linkTemplate = %q, want %q", template, tt.template)
+			}
+		})
+	}
+}
+
+func TestParseLinkTarget(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		messageID int
+		token     string
+		err       bool
+	}{
+		{name: "message ID", target: " 10 ", messageID: 10},
+		{name: "bare hash", target: "abc", token: "abc"},
+		{name: "stream link", target: "https://example.com/stream/10?hash=abc", messageID: 10, token: "abc"},
+		{name: "download link", target: "https://example.com/stream/10?hash=abc&d=true", messageID: 10, token: "abc"},
+		{name: "watch link", target: "https://example.com/watch/10?hash=abc", messageID: 10, token: "abc"},
+		{name: "escaped hash", target: "https://example.com/stream/10?hash=a%2Bb", messageID: 10, token: "a+b"},
+		{name: "proxy link", target: "https://player.example/?video=10?hash=abc", messageID: 10, token: "abc"},
+		{name: "bundle link", target: "https://example.com/zip?bundle=xyz", token: "xyz"},
+		{name: "link without hash", target: "https://example.com/stream/10", err: true},
+		{name: "text", target: "not a link", err: true},
+		{name: "empty", target: "", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messageID, token, err := ParseLinkTarget(tt.target)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("ParseLinkTarget = %d, %q, want an error", messageID, token)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if messageID != tt.messageID || token != tt.token {
+				t.Fatalf("ParseLinkTarget = %d, %q, want %d, %q", messageID, token, tt.messageID, tt.token)
+			}
+		})
+	}
+}
@@ -1,20 +1,44 @@
 package utils
 
 import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
+	"strconv"
+	"sync"
 
 	"github.com/celestix/gotgproto"
 	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
+// ClientSwitcher returns a client to continue a stream on once the current
+// one fails. Clients whose user IDs are in failed must not be returned; nil
+// means there is nothing left to try.
+type ClientSwitcher func(failed []int64) *gotgproto.Client
+
 type telegramReader struct {
 	ctx           context.Context
+	cancel        context.CancelFunc
 	log           *zap.Logger
+	mu            sync.Mutex
 	client        *gotgproto.Client
+	switcher      ClientSwitcher
+	failed        []int64
+	switches      singleflight.Group
+	messageID     int
 	location      tg.InputFileLocationClass
+	thumbType     string
+	dcID          int
+	cdn           *cdnFile
+	cdnClient     *gotgproto.Client
 	start         int64
 	end           int64
 	next          func() ([]byte, error)
@@ -23,30 +47,45 @@ type telegramReader struct {
 	chunkSize     int64
 	i             int64
 	contentLength int64
+	prefetch      int
+}
+
+// chunkResult is a downloaded part waiting to be consumed by the reader.
+type chunkResult struct {
+	data []byte
+	err  error
 }
 
-func (*telegramReader) Close() error {
+func (r *telegramReader) Close() error {
+	r.cancel()
 	return nil
 }
 
 func NewTelegramReader(
 	ctx context.Context,
 	client *gotgproto.Client,
-	location tg.InputFileLocationClass,
+	switcher ClientSwitcher,
+	messageID int,
+	file *types.File,
 	start int64,
 	end int64,
 	contentLength int64,
 ) (io.ReadCloser, error) {
-
+	ctx, cancel := context.WithCancel(ctx)
 	r := &telegramReader{
 		ctx:           ctx,
+		cancel:        cancel,
 		log:           Logger.Named("telegramReader"),
-		location:      location,
+		location:      file.Location,
+		thumbType:     file.ThumbType(),
 		client:        client,
+		switcher:      switcher,
+		messageID:     messageID,
 		start:         start,
 		end:           end,
 		chunkSize:     int64(1024 * 1024),
 		contentLength: contentLength,
+		prefetch:      config.ValueOf.StreamConcurrency,
 	}
 	r.log.Sugar().Debug("Start")
 	r.next = r.partStream()
@@ -67,12 +106,7 @@ func (r *telegramReader) Read(p []byte) (n int, err error) {
 			return 0, err
 		}
 		if len(r.buffer) == 0 {
-			r.next = r.partStream()
-			r.buffer, err = r.next()
-			if err != nil {
-				return 0, err
-			}
-
+			return 0, io.ErrUnexpectedEOF
 		}
 		r.i = 0
 	}
@@ -82,15 +116,158 @@ func (r *telegramReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// chunkKey identifies a chunk of a file independently of the client that
+// fetches it, so it can be shared between streams.
+func chunkKey(location tg.InputFileLocationClass, offset int64) string {
+	switch location := location.(type) {
+	case *tg.InputDocumentFileLocation:
+		if location.ThumbSize != "" {
+			return fmt.Sprintf("doc%d_%s_%d", location.ID, location.ThumbSize, offset)
+		}
+		return fmt.Sprintf("doc%d_%d", location.ID, offset)
+	case *tg.InputPhotoFileLocation:
+		return fmt.Sprintf("photo%d_%s_%d", location.ID, location.ThumbSize, offset)
+	}
+	return ""
+}
+
+// chunkDownloads collapses concurrent downloads of the same chunk, across all
+// streams of the process, into a single request to Telegram.
+var chunkDownloads singleflight.Group
+
+// fetch returns a part from the chunk cache when possible and downloads it
+// otherwise, filling the cache on the way. Readers asking for a chunk that is
+// already being downloaded wait for that download instead of starting another.
+func (r *telegramReader) fetch(offset int64) ([]byte, error) {
+	_, location := r.source()
+	key := chunkKey(location, offset)
+	chunkCache := cache.GetChunkCache()
+	if chunkCache != nil && key != "" {
+		if data, ok := chunkCache.Get(key); ok {
+			r.log.Debug("Chunk cache hit", zap.String("key", key))
+			return data, nil
+		}
+	}
+	if key == "" {
+		return r.chunk(offset, r.chunkSize)
+	}
+	for {
+		pending := chunkDownloads.DoChan(key, func() (interface{}, error) {
+			data, err := r.chunk(offset, r.chunkSize)
+			if err == nil && chunkCache != nil {
+				chunkCache.Put(key, data)
+			}
+			return data, err
+		})
+		select {
+		case res := <-pending:
+			if res.Err != nil {
+				// The stream that started the shared download may have been
+				// closed by its viewer, which doesn't concern this one.
+				if errors.Is(res.Err, context.Canceled) && r.ctx.Err() == nil {
+					continue
+				}
+				return nil, res.Err
+			}
+			if res.Shared {
+				r.log.Debug("Shared chunk download", zap.String("key", key))
+			}
+			return res.Val.([]byte), nil
+		case <-r.ctx.Done():
+			return nil, r.ctx.Err()
+		}
+	}
+}
+
+// chunk downloads a single part, moving the stream to another worker and
+// retrying the same offset whenever the current one fails.
 func (r *telegramReader) chunk(offset int64, limit int64) ([]byte, error) {
+	refreshed := false
+	for {
+		client, location := r.source()
+		data, err := r.download(client, location, offset, limit)
+		if err == nil {
+			return data, nil
+		}
+		if r.ctx.Err() != nil {
+			return nil, r.ctx.Err()
+		}
+		if tg.IsFileReferenceExpired(err) && !refreshed {
+			refreshed = true
+			if err = r.refresh(client, location); err == nil {
+				continue
+			}
+		}
+		if err := r.failover(client, err); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// refresh fetches a new file reference for the current client. Like failover,
+// only the first part to notice the expired reference does the work, and the
+// request to Telegram is made without holding r.mu.
+func (r *telegramReader) refresh(client *gotgproto.Client, expired tg.InputFileLocationClass) error {
+	_, err, _ := r.switches.Do("refresh"+strconv.FormatInt(client.Self.ID, 10), func() (interface{}, error) {
+		if current, location := r.source(); current != client || location != expired {
+			return nil, nil
+		}
+		file, err := RefreshFile(r.ctx, client, r.messageID)
+		if err == nil {
+			file, err = r.withThumb(file)
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.log.Debug("Refreshed expired file reference", zap.Int("messageID", r.messageID))
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.client == client {
+			r.location = file.Location
+		}
+		return nil, nil
+	})
+	return err
+}
+
+func (r *telegramReader) source() (*gotgproto.Client, tg.InputFileLocationClass) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.client, r.location
+}
+
+func (r *telegramReader) download(client *gotgproto.Client, location tg.InputFileLocationClass, offset int64, limit int64) ([]byte, error) {
+	if cdn := r.cdnFor(client); cdn != nil {
+		data, err := cdn.chunk(r.ctx, offset, limit)
+		if err == nil || r.ctx.Err() != nil {
+			return data, err
+		}
+		r.dropCDN(cdn, err)
+	}
+
+	api, err := r.api(client)
+	if err != nil {
+		return nil, err
+	}
 
 	req := &tg.UploadGetFileRequest{
-		Offset:   offset,
-		Limit:    int(limit),
-		Location: r.location,
+		Offset:       offset,
+		Limit:        int(limit),
+		Location:     location,
+		CDNSupported: true,
 	}
 
-	res, err := r.client.API().UploadGetFile(r.ctx, req)
+	res, err := api.UploadGetFile(r.ctx, req)
+
+	// the file lives on another DC than the worker's own one
+	if rpcErr, ok := tgerr.AsType(err, "FILE_MIGRATE"); ok && rpcErr.Argument != r.fileDC() {
+		r.log.Debug("File is on another DC", zap.Int("dcID", rpcErr.Argument))
+		r.setFileDC(rpcErr.Argument)
+		if api, err = r.api(client); err != nil {
+			return nil, err
+		}
+		res, err = api.UploadGetFile(r.ctx, req)
+	}
 
 	if err != nil {
 		return nil, err
@@ -99,9 +276,173 @@ func (r *telegramReader) chunk(offset int64, limit int64) ([]byte, error) {
 	switch result := res.(type) {
 	case *tg.UploadFile:
 		return result.Bytes, nil
+	case *tg.UploadFileCDNRedirect:
+		if cdns.available(result.DCID) {
+			cdnAPI, err := cdns.get(r.ctx, api, result.DCID)
+			if err == nil {
+				cdn := newCDNFile(result, api, cdnAPI)
+				r.setCDN(client, cdn)
+				data, err := cdn.chunk(r.ctx, offset, limit)
+				if err == nil || r.ctx.Err() != nil {
+					return data, err
+				}
+				r.dropCDN(cdn, err)
+			} else if r.ctx.Err() == nil {
+				cdns.markUnreachable(result.DCID)
+				r.log.Warn("CDN DC is unreachable", zap.Int("dcID", result.DCID), zap.Error(err))
+			}
+		}
+		// download the part from the master DC instead
+		req.CDNSupported = false
+		res, err = api.UploadGetFile(r.ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if result, ok := res.(*tg.UploadFile); ok {
+			return result.Bytes, nil
+		}
+		return nil, fmt.Errorf("unexpected type %T", res)
 	default:
-		return nil, fmt.Errorf("unexpected type %T", r)
+		return nil, fmt.Errorf("unexpected type %T", result)
+	}
+}
+
+// cdnFor returns the CDN redirect of the stream if it was issued to client,
+// whose DC has to be asked for the hashes of the parts.
+func (r *telegramReader) cdnFor(client *gotgproto.Client) *cdnFile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cdnClient != client {
+		return nil
+	}
+	return r.cdn
+}
+
+func (r *telegramReader) setCDN(client *gotgproto.Client, cdn *cdnFile) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cdn = cdn
+	r.cdnClient = client
+}
+
+// dropCDN stops using a CDN that failed to serve a part. The CDN DC is then
+// skipped for a while and parts are downloaded from the master DC.
+func (r *telegramReader) dropCDN(cdn *cdnFile, cause error) {
+	r.mu.Lock()
+	if r.cdn == cdn {
+		r.cdn = nil
+		r.cdnClient = nil
+	}
+	r.mu.Unlock()
+	cdns.markUnreachable(cdn.redirect.DCID)
+	r.log.Warn("Failed to download from CDN", zap.Int("dcID", cdn.redirect.DCID), zap.Error(cause))
+}
+
+// api returns the client to request file parts from, which is a pooled
+// connection to the file's DC once Telegram told us where the file lives.
+func (r *telegramReader) api(client *gotgproto.Client) (*tg.Client, error) {
+	if dcID := r.fileDC(); dcID != 0 {
+		return DCClient(r.ctx, client, dcID)
+	}
+	return client.API(), nil
+}
+
+func (r *telegramReader) fileDC() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dcID
+}
+
+func (r *telegramReader) setFileDC(dcID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dcID = dcID
+}
+
+// withThumb points a freshly resolved file at the size this stream serves.
+func (r *telegramReader) withThumb(file *types.File) (*types.File, error) {
+	if r.thumbType == "" || file.ThumbType() == r.thumbType {
+		return file, nil
 	}
+	return FileWithThumb(file, r.thumbType)
+}
+
+// failover replaces the failed client with the next available worker and
+// re-resolves the file location on it. Parts that are downloaded in parallel
+// may fail on the same client at once, in which case only the first one
+// switches and the rest wait for it and retry on the new client.
+func (r *telegramReader) failover(failed *gotgproto.Client, cause error) error {
+	_, err, _ := r.switches.Do(strconv.FormatInt(failed.Self.ID, 10), func() (interface{}, error) {
+		return nil, r.switchClient(failed, cause)
+	})
+	return err
+}
+
+// switchClient finds the next worker without holding r.mu, as resolving the
+// file on a worker takes a request to Telegram, and swaps it in under the
+// lock once it is ready.
+func (r *telegramReader) switchClient(failed *gotgproto.Client, cause error) error {
+	r.mu.Lock()
+	if r.client != failed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.failed = append(r.failed, failed.Self.ID)
+	excluded := slices.Clone(r.failed)
+	r.mu.Unlock()
+	if r.switcher == nil {
+		return cause
+	}
+	for {
+		next := r.switcher(excluded)
+		if next == nil {
+			return fmt.Errorf("no workers left to stream from: %w", cause)
+		}
+		file, err := FileFromMessage(r.ctx, next, r.messageID)
+		if err == nil {
+			file, err = r.withThumb(file)
+		}
+		if err != nil {
+			r.log.Warn("Failed to resolve file on worker", zap.Int64("workerID", next.Self.ID), zap.Error(err))
+			excluded = append(excluded, next.Self.ID)
+			r.mu.Lock()
+			r.failed = append(r.failed, next.Self.ID)
+			r.mu.Unlock()
+			continue
+		}
+		r.log.Warn("Switching worker mid-stream",
+			zap.Int64("from", failed.Self.ID),
+			zap.Int64("to", next.Self.ID),
+			zap.Error(cause))
+		r.mu.Lock()
+		r.client = next
+		r.location = file.Location
+		r.mu.Unlock()
+		return nil
+	}
+}
+
+// prefetchParts downloads up to r.prefetch parts ahead of the consumer. Parts are
+// handed out in order through the returned queue, so at most r.prefetch+1
+// chunks are held in memory for a single stream.
+func (r *telegramReader) prefetchParts(offset int64, partCount int) <-chan chan chunkResult {
+	queue := make(chan chan chunkResult, r.prefetch)
+	go func() {
+		defer close(queue)
+		for part := 0; part < partCount; part++ {
+			result := make(chan chunkResult, 1)
+			select {
+			case queue <- result:
+			case <-r.ctx.Done():
+				return
+			}
+			go func(offset int64) {
+				data, err := r.fetch(offset)
+				result <- chunkResult{data: data, err: err}
+			}(offset + int64(part)*r.chunkSize)
+		}
+	}()
+	return queue
 }
 
 func (r *telegramReader) partStream() func() ([]byte, error) {
@@ -115,26 +456,39 @@ func (r *telegramReader) partStream() func() ([]byte, error) {
 	partCount := int((end - offset + r.chunkSize) / r.chunkSize)
 	currentPart := 1
 
+	parts := r.prefetchParts(offset, partCount)
+
 	readData := func() ([]byte, error) {
 		if currentPart > partCount {
 			return make([]byte, 0), nil
 		}
-		res, err := r.chunk(offset, r.chunkSize)
+		pending, ok := <-parts
+		if !ok {
+			return nil, r.ctx.Err()
+		}
+		var part chunkResult
+		select {
+		case part = <-pending:
+		case <-r.ctx.Done():
+			return nil, r.ctx.Err()
+		}
+		res, err := part.data, part.err
 		if err != nil {
 			return nil, err
 		}
 		if len(res) == 0 {
 			return res, nil
+		} else if currentPart == 1 && int64(len(res)) <= firstPartCut {
+			return nil, fmt.Errorf("part of %d bytes ends before the requested offset %d", len(res), firstPartCut)
 		} else if partCount == 1 {
-			res = res[firstPartCut:lastPartCut]
+			res = res[firstPartCut:min(lastPartCut, int64(len(res)))]
 		} else if currentPart == 1 {
 			res = res[firstPartCut:]
 		} else if currentPart == partCount {
-			res = res[:lastPartCut]
+			res = res[:min(lastPartCut, int64(len(res)))]
 		}
 
 		currentPart++
-		offset += r.chunkSize
 		r.log.Sugar().Debugf("Part %d/%d", currentPart, partCount)
 		return res, nil
 	}
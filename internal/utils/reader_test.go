@@ -0,0 +1,347 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/celestix/gotgproto"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"go.uber.org/zap"
+)
+
+const testLogChannelID = 1000
+
+// fakeTelegram answers the requests of test workers for a single log channel
+// message holding a document, without connecting anywhere.
+type fakeTelegram struct {
+	mu         sync.Mutex
+	messageID  int
+	documentID int64
+	data       []byte
+	reference  []byte
+	thumbs     []tg.PhotoSizeClass
+	broken     map[int64]bool // workers whose downloads fail
+	expiring   bool           // every file reference is expired
+	refreshed  int            // times the message was fetched
+	downloads  int
+	lastThumb  string
+}
+
+func newFakeTelegram(documentID int64, size int) *fakeTelegram {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return &fakeTelegram{
+		messageID:  10,
+		documentID: documentID,
+		data:       data,
+		reference:  []byte{1},
+		broken:     make(map[int64]bool),
+	}
+}
+
+func (f *fakeTelegram) handle(workerID int64, input bin.Encoder) (bin.Encoder, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch req := input.(type) {
+	case *tg.ChannelsGetMessagesRequest:
+		f.refreshed++
+		return &tg.MessagesChannelMessages{
+			Messages: []tg.MessageClass{&tg.Message{
+				ID:     f.messageID,
+				PeerID: &tg.PeerChannel{ChannelID: testLogChannelID},
+				Media: &tg.MessageMediaDocument{Document: &tg.Document{
+					ID:            f.documentID,
+					AccessHash:    1,
+					FileReference: f.reference,
+					Size:          int64(len(f.data)),
+					MimeType:      "video/mp4",
+					Thumbs:        f.thumbs,
+				}},
+			}},
+			Chats: []tg.ChatClass{},
+			Users: []tg.UserClass{},
+		}, nil
+	case *tg.UploadGetFileRequest:
+		f.downloads++
+		if f.broken[workerID] {
+			return nil, tgerr.New(500, "INTERNAL")
+		}
+		location, ok := req.Location.(*tg.InputDocumentFileLocation)
+		if !ok || location.ID != f.documentID {
+			return nil, tgerr.New(400, "LOCATION_INVALID")
+		}
+		if f.expiring || !bytes.Equal(location.FileReference, f.reference) {
+			return nil, tgerr.New(400, "FILE_REFERENCE_EXPIRED")
+		}
+		f.lastThumb = location.ThumbSize
+		start := min(req.Offset, int64(len(f.data)))
+		end := min(req.Offset+int64(req.Limit), int64(len(f.data)))
+		return &tg.UploadFile{Type: &tg.StorageFileUnknown{}, Bytes: f.data[start:end]}, nil
+	}
+	return nil, fmt.Errorf("unexpected request %T", input)
+}
+
+// worker returns a client whose requests are answered by f.
+func (f *fakeTelegram) worker(id int64) *gotgproto.Client {
+	api := telegram.NewClient(1, "hash", telegram.Options{
+		Middlewares: []telegram.Middleware{
+			telegram.MiddlewareFunc(func(tg.Invoker) telegram.InvokeFunc {
+				return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+					res, err := f.handle(id, input)
+					if err != nil {
+						return err
+					}
+					var buf bin.Buffer
+					if err := res.Encode(&buf); err != nil {
+						return err
+					}
+					return output.Decode(&buf)
+				}
+			}),
+		},
+	})
+	peers := storage.NewPeerStorage(nil, true)
+	peers.AddPeer(testLogChannelID, 1, storage.TypeChannel, "")
+	return &gotgproto.Client{Client: api, Self: &tg.User{ID: id}, PeerStorage: peers}
+}
+
+// withTelegram prepares the globals a reader depends on.
+func withTelegram(t *testing.T) {
+	t.Helper()
+	old := *config.ValueOf
+	t.Cleanup(func() { *config.ValueOf = old })
+	config.ValueOf.LogChannelID = testLogChannelID
+	config.ValueOf.StreamConcurrency = 2
+	if Logger == nil {
+		Logger = zap.NewNop()
+	}
+	cache.InitCache(zap.NewNop())
+}
+
+// readAll streams the whole file of f from client, or its thumbnail of
+// thumbType.
+func (f *fakeTelegram) readAll(t *testing.T, client *gotgproto.Client, switcher ClientSwitcher, thumbType string) ([]byte, error) {
+	t.Helper()
+	file, err := FileFromMessage(context.Background(), client, f.messageID)
+	if err == nil && thumbType != "" {
+		file, err = FileWithThumb(file, thumbType)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewTelegramReader(context.Background(), client, switcher, f.messageID, file, 0, file.FileSize-1, file.FileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		// wait for the parts still in flight, as they use the globals the
+		// next test replaces
+		r := reader.(*telegramReader)
+		for {
+			if part, err := r.next(); err == nil && len(part) == 0 {
+				break
+			}
+		}
+	}
+	return data, err
+}
+
+func TestChunkKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		location tg.InputFileLocationClass
+		offset   int64
+		key      string
+	}{
+		{name: "document", location: &tg.InputDocumentFileLocation{ID: 7, FileReference: []byte{1}}, offset: 1024, key: "doc7_1024"},
+		{name: "document thumbnail", location: &tg.InputDocumentFileLocation{ID: 7, ThumbSize: "m"}, key: "doc7_m_0"},
+		{name: "photo", location: &tg.InputPhotoFileLocation{ID: 7, ThumbSize: "y"}, offset: 1024, key: "photo7_y_1024"},
+		{name: "other location", location: &tg.InputPeerPhotoFileLocation{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if key := chunkKey(tt.location, tt.offset); key != tt.key {
+				t.Fatalf("chunkKey = %q, want %q", key, tt.key)
+			}
+		})
+	}
+}
+
+func TestReaderChunkCache(t *testing.T) {
+	withTelegram(t)
+	config.ValueOf.ChunkCacheDir = t.TempDir()
+	config.ValueOf.ChunkCacheSize = 8
+	cache.InitChunkCache(zap.NewNop())
+
+	// two and a half parts
+	f := newFakeTelegram(0x7001, 5<<19)
+	worker := f.worker(1)
+	for i, downloads := range []int{3, 3} {
+		data, err := f.readAll(t, worker, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, f.data) {
+			t.Fatalf("stream %d returned other data", i)
+		}
+		if f.downloads != downloads {
+			t.Fatalf("%d parts downloaded after stream %d, want %d", f.downloads, i, downloads)
+		}
+	}
+	for offset := int64(0); offset < int64(len(f.data)); offset += 1 << 20 {
+		if _, ok := cache.GetChunkCache().Get(chunkKey(&tg.InputDocumentFileLocation{ID: f.documentID}, offset)); !ok {
+			t.Fatalf("part at %d is not cached", offset)
+		}
+	}
+}
+
+// switchTo hands out the workers in order, skipping the failed ones.
+func switchTo(workers ...*gotgproto.Client) ClientSwitcher {
+	return func(failed []int64) *gotgproto.Client {
+		for _, worker := range workers {
+			if !Contains(failed, worker.Self.ID) {
+				return worker
+			}
+		}
+		return nil
+	}
+}
+
+func TestReaderFailover(t *testing.T) {
+	tests := []struct {
+		name      string
+		broken    []int64
+		noSwitch  bool
+		thumbType string
+		err       bool
+	}{
+		{name: "healthy worker"},
+		{name: "next worker", broken: []int64{1}},
+		{name: "several broken workers", broken: []int64{1, 2}},
+		{name: "thumbnail", broken: []int64{1}, thumbType: "m"},
+		{name: "no workers left", broken: []int64{1, 2, 3}, err: true},
+		{name: "no switcher", broken: []int64{1}, noSwitch: true, err: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withTelegram(t)
+			// a chunk cache left by another test must not serve the parts
+			f := newFakeTelegram(0x7100+int64(i), 3<<19)
+			f.thumbs = []tg.PhotoSizeClass{&tg.PhotoSize{Type: "m", W: 320, H: 180, Size: len(f.data)}}
+			for _, id := range tt.broken {
+				f.broken[id] = true
+			}
+			workers := []*gotgproto.Client{f.worker(1), f.worker(2), f.worker(3)}
+			switcher := switchTo(workers...)
+			if tt.noSwitch {
+				switcher = nil
+			}
+
+			data, err := f.readAll(t, workers[0], switcher, tt.thumbType)
+			if tt.err {
+				if err == nil {
+					t.Fatal("the stream succeeded without a working worker")
+				}
+				if !tgerr.Is(err, "INTERNAL") {
+					t.Fatalf("error = %v, want the cause of the last switch", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, f.data) {
+				t.Fatal("the stream returned other data")
+			}
+			if f.lastThumb != tt.thumbType {
+				t.Fatalf("the new worker downloaded size %q, want %q", f.lastThumb, tt.thumbType)
+			}
+		})
+	}
+}
+
+func TestSwitchClientStale(t *testing.T) {
+	withTelegram(t)
+	f := newFakeTelegram(0x7200, 1024)
+	failed, current := f.worker(1), f.worker(2)
+	r := &telegramReader{ctx: context.Background(), log: zap.NewNop(), client: current, switcher: switchTo(f.worker(3))}
+
+	// a part that failed on a client the stream already left
+	if err := r.switchClient(failed, errors.New("old failure")); err != nil {
+		t.Fatal(err)
+	}
+	if r.client != current || len(r.failed) != 0 {
+		t.Fatalf("switched away from the current worker %d to %d", current.Self.ID, r.client.Self.ID)
+	}
+}
+
+func TestReaderRefresh(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiring  bool
+		thumbType string
+		err       bool
+	}{
+		{name: "expired reference"},
+		{name: "expired thumbnail reference", thumbType: "m"},
+		{name: "reference keeps expiring", expiring: true, err: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withTelegram(t)
+			f := newFakeTelegram(0x7300+int64(i), 3<<19)
+			f.thumbs = []tg.PhotoSizeClass{&tg.PhotoSize{Type: "m", W: 320, H: 180, Size: len(f.data)}}
+			worker := f.worker(1)
+			// cache the file with the reference that is about to expire
+			if _, err := FileFromMessage(context.Background(), worker, f.messageID); err != nil {
+				t.Fatal(err)
+			}
+			f.reference = []byte{2}
+			f.expiring = tt.expiring
+
+			// without a switcher, any failover fails the stream
+			data, err := f.readAll(t, worker, nil, tt.thumbType)
+			if tt.err {
+				if !tg.IsFileReferenceExpired(err) {
+					t.Fatalf("error = %v, want the expired reference", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, f.data) {
+				t.Fatal("the stream returned other data")
+			}
+			if f.lastThumb != tt.thumbType {
+				t.Fatalf("the refreshed stream downloaded size %q, want %q", f.lastThumb, tt.thumbType)
+			}
+			// the parts that failed at once refreshed the reference together
+			if f.refreshed != 2 {
+				t.Fatalf("message fetched %d times, want 2", f.refreshed)
+			}
+			file, err := FileFromMessage(context.Background(), worker, f.messageID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reference := file.Location.(*tg.InputDocumentFileLocation).FileReference; !bytes.Equal(reference, f.reference) {
+				t.Fatalf("cached reference = %v, want %v", reference, f.reference)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	linkTokenVersion = 1
+	linkMACSize      = 16
+	linkSecretFile   = "data/link_secret"
+)
+
+const (
+	// FlagDownloadOnly makes a link serve the file only as an attachment.
+	FlagDownloadOnly uint8 = 1 << iota
+)
+
+var (
+	ErrInvalidLink = errors.New("invalid hash")
+	ErrLinkExpired = errors.New("link expired")
+	ErrLinkRevoked = errors.New("link revoked")
+	ErrLinkUsedUp  = errors.New("link usage limit reached")
+)
+
+// LinkClaims are carried by a signed link next to its MAC.
+type LinkClaims struct {
+	Expiry int64 // unix time, 0 for links that never expire
+	Flags  uint8
+	LinkID uint  // record of a link with usage limits, 0 for none
+	Kind   uint8 // tells the links of a file apart, so each can be revoked alone
+}
+
+func (c *LinkClaims) DownloadOnly() bool {
+	return c.Flags&FlagDownloadOnly != 0
+}
+
+var (
+	linkSecret     []byte
+	previousSecret []byte
+)
+
+// InitLinkSigning loads the secret used to sign links. When LINK_SECRET is not
+// set a random one is generated once and kept in the data directory, so links
+// stay valid across restarts.
+func InitLinkSigning(log *zap.Logger) {
+	log = log.Named("LinkSigning")
+	secret := config.ValueOf.LinkSecret
+	if secret == "" {
+		var err error
+		secret, err = loadOrCreateSecret(filepath.Clean(linkSecretFile))
+		if err != nil {
+			log.Fatal("Failed to load the link secret", zap.Error(err))
+		}
+		log.Sugar().Infof("LINK_SECRET not set, using the one stored in %s", linkSecretFile)
+	}
+	linkSecret = []byte(secret)
+	if config.ValueOf.PreviousLinkSecret != "" {
+		previousSecret = []byte(config.ValueOf.PreviousLinkSecret)
+		if until := config.ValueOf.PreviousLinkSecretUntil; !until.IsZero() {
+			log.Sugar().Infof("Accepting links signed with the previous secret until %s", until.Format(time.RFC3339))
+		}
+	}
+	if !config.ValueOf.AllowLegacyHash {
+		log.Sugar().Info("Legacy link hashes are disabled")
+	}
+}
+
+func loadOrCreateSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(raw)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// DefaultLinkClaims returns the claims of newly sent links.
+func DefaultLinkClaims() LinkClaims {
+	var claims LinkClaims
+	if expiry := config.ValueOf.LinkExpiry; expiry > 0 {
+		claims.Expiry = time.Now().Add(expiry).Unix()
+	}
+	return claims
+}
+
+func (c *LinkClaims) encode() string {
+	buf := make([]byte, 2, 2+2*binary.MaxVarintLen64)
+	buf[0] = linkTokenVersion
+	buf[1] = c.Flags
+	buf = binary.AppendUvarint(buf, uint64(c.Expiry))
+	if c.LinkID != 0 || c.Kind != 0 {
+		buf = binary.AppendUvarint(buf, uint64(c.LinkID))
+	}
+	if c.Kind != 0 {
+		buf = append(buf, c.Kind)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeLinkClaims(payload string) (*LinkClaims, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || len(buf) < 3 || buf[0] != linkTokenVersion {
+		return nil, ErrInvalidLink
+	}
+	claims := &LinkClaims{Flags: buf[1]}
+	expiry, n := binary.Uvarint(buf[2:])
+	if n <= 0 {
+		return nil, ErrInvalidLink
+	}
+	claims.Expiry = int64(expiry)
+	// fields added later are optional, so older tokens stay valid
+	rest := buf[2+n:]
+	if len(rest) > 0 {
+		linkID, n := binary.Uvarint(rest)
+		if n <= 0 {
+			return nil, ErrInvalidLink
+		}
+		claims.LinkID = uint(linkID)
+		rest = rest[n:]
+	}
+	if len(rest) > 0 {
+		claims.Kind = rest[0]
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		return nil, ErrInvalidLink
+	}
+	return claims, nil
+}
+
+// linkMAC binds the claims to a message and to the file in it, so a link
+// can't be reused after the message is replaced by another file.
+func linkMAC(secret []byte, kind string, subject string, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(kind + ":" + subject + ":" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:linkMACSize])
+}
+
+func linkSubject(messageID int, file *types.File) string {
+	return strconv.Itoa(messageID) + ":" + strconv.FormatInt(file.ID, 10)
+}
+
+// verifyMAC checks a MAC against the current secret, and against the previous
+// one while its grace period lasts.
+func verifyMAC(kind string, subject string, payload string, mac string) bool {
+	if hmac.Equal([]byte(mac), []byte(linkMAC(linkSecret, kind, subject, payload))) {
+		return true
+	}
+	if previousSecret == nil {
+		return false
+	}
+	if until := config.ValueOf.PreviousLinkSecretUntil; !until.IsZero() && time.Now().After(until) {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(linkMAC(previousSecret, kind, subject, payload)))
+}
+
+// SignLink returns the hash parameter of a signed link to a file.
+func SignLink(messageID int, file *types.File, claims LinkClaims) string {
+	payload := claims.encode()
+	return payload + "." + linkMAC(linkSecret, "file", linkSubject(messageID, file), payload)
+}
+
+// IsSignedHash tells signed link tokens apart from legacy hex hashes.
+func IsSignedHash(hash string) bool {
+	return strings.Contains(hash, ".")
+}
+
+// VerifyLink checks the hash parameter of a link to a file. Signed links are
+// checked against the secret and their expiry, legacy hashes are accepted
+// only while ALLOW_LEGACY_HASH is set and carry no claims. Revoked messages
+// and links are rejected either way, as are links that used up their limits.
+func VerifyLink(hash string, messageID int, file *types.File) (*LinkClaims, error) {
+	if isRevoked(messageID, hash) {
+		return nil, ErrLinkRevoked
+	}
+	if !IsSignedHash(hash) {
+		if config.ValueOf.AllowLegacyHash && CheckHash(hash, FileHash(file)) {
+			return &LinkClaims{}, nil
+		}
+		return nil, ErrInvalidLink
+	}
+	payload, mac, _ := strings.Cut(hash, ".")
+	if !verifyMAC("file", linkSubject(messageID, file), payload, mac) {
+		return nil, ErrInvalidLink
+	}
+	claims, err := decodeLinkClaims(payload)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Expiry != 0 && time.Now().Unix() > claims.Expiry {
+		return nil, ErrLinkExpired
+	}
+	if err := CheckLinkUsage(claims.LinkID); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// CheckLinkUsage returns ErrLinkUsedUp once the link with limits linkID has
+// used them up. Links without limits, with linkID 0, always pass.
+func CheckLinkUsage(linkID uint) error {
+	if linkID == 0 {
+		return nil
+	}
+	links := cache.GetLinks()
+	if links == nil {
+		return errors.New("link limits are not available")
+	}
+	exhausted, err := links.Exhausted(linkID)
+	if errors.Is(err, cache.ErrLinkNotFound) {
+		return ErrInvalidLink
+	}
+	if err != nil {
+		return err
+	}
+	if exhausted {
+		return ErrLinkUsedUp
+	}
+	return nil
+}
+
+func isRevoked(messageID int, token string) bool {
+	revocations := cache.GetRevocations()
+	return revocations != nil && revocations.IsRevoked(messageID, token)
+}
+
+// SignCallback appends a MAC to the data of an inline button, so callback
+// handlers can trust the IDs in it. Telegram limits the data to 64 bytes.
+func SignCallback(data string) string {
+	mac := hmac.New(sha256.New, linkSecret)
+	mac.Write([]byte("callback:" + data))
+	return data + ":" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// VerifyCallback checks the data of an inline button signed by SignCallback
+// and returns it without its MAC.
+func VerifyCallback(signed string) (string, bool) {
+	i := strings.LastIndex(signed, ":")
+	if i < 0 {
+		return "", false
+	}
+	data := signed[:i]
+	return data, hmac.Equal([]byte(signed), []byte(SignCallback(data)))
+}
+
+// SignUnlock returns the cookie value that proves the password of a message
+// was entered, valid until expiry.
+func SignUnlock(messageID int, expiry time.Time) string {
+	payload := strconv.FormatInt(expiry.Unix(), 36)
+	return payload + "." + linkMAC(linkSecret, "unlock", strconv.Itoa(messageID), payload)
+}
+
+// VerifyUnlock checks a cookie value made by SignUnlock.
+func VerifyUnlock(value string, messageID int) bool {
+	payload, mac, ok := strings.Cut(value, ".")
+	if !ok || !verifyMAC("unlock", strconv.Itoa(messageID), payload, mac) {
+		return false
+	}
+	expiry, err := strconv.ParseInt(payload, 36, 64)
+	return err == nil && time.Now().Unix() < expiry
+}
@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+// withSecrets sets the signing secrets and the config they depend on for
+// the duration of a test.
+func withSecrets(t *testing.T, current, previous string, until time.Time) {
+	t.Helper()
+	oldSecret, oldPrevious, oldConfig := linkSecret, previousSecret, *config.ValueOf
+	t.Cleanup(func() {
+		linkSecret, previousSecret, *config.ValueOf = oldSecret, oldPrevious, oldConfig
+	})
+	linkSecret = []byte(current)
+	previousSecret = nil
+	if previous != "" {
+		previousSecret = []byte(previous)
+	}
+	config.ValueOf.PreviousLinkSecretUntil = until
+	config.ValueOf.AllowLegacyHash = true
+	config.ValueOf.HashLength = 6
+}
+
+func testFile(id int64) *types.File {
+	return &types.File{
+		Location: &tg.InputDocumentFileLocation{ID: id},
+		FileSize: 1024,
+		FileName: "video.mp4",
+		MimeType: "video/mp4",
+		ID:       id,
+	}
+}
+
+func TestLinkClaimsEncoding(t *testing.T) {
+	tests := []LinkClaims{
+		{},
+		{Expiry: 1700000000},
+		{Flags: FlagDownloadOnly},
+		{Expiry: 1700000000, Flags: FlagDownloadOnly, LinkID: 42},
+		{LinkID: 1 << 40},
+		{Kind: 2},
+		{Expiry: 1700000000, LinkID: 42, Kind: 3},
+	}
+	for _, claims := range tests {
+		decoded, err := decodeLinkClaims(claims.encode())
+		if err != nil {
+			t.Fatalf("decodeLinkClaims(%+v) error = %v", claims, err)
+		}
+		if *decoded != claims {
+			t.Fatalf("decodeLinkClaims(%+v) = %+v", claims, *decoded)
+		}
+	}
+
+	for _, payload := range []string{"", "!!", "AA", "AgAA", "AQCA", "AQAAAAEB"} {
+		if _, err := decodeLinkClaims(payload); !errors.Is(err, ErrInvalidLink) {
+			t.Fatalf("decodeLinkClaims(%q) error = %v, want ErrInvalidLink", payload, err)
+		}
+	}
+}
+
+func TestVerifyLink(t *testing.T) {
+	now := time.Now()
+	withSecrets(t, "current", "previous", now.Add(time.Hour))
+	file := testFile(7)
+
+	signedWith := func(secret string, claims LinkClaims) string {
+		old := linkSecret
+		linkSecret = []byte(secret)
+		defer func() { linkSecret = old }()
+		return SignLink(1, file, claims)
+	}
+	legacy := GetShortHash(FileHash(file))
+
+	tests := []struct {
+		name      string
+		hash      string
+		messageID int
+		file      *types.File
+		err       error
+	}{
+		{name: "signed", hash: SignLink(1, file, LinkClaims{}), messageID: 1, file: file},
+		{name: "not expired", hash: SignLink(1, file, LinkClaims{Expiry: now.Add(time.Minute).Unix()}), messageID: 1, file: file},
+		{name: "expired", hash: SignLink(1, file, LinkClaims{Expiry: now.Add(-time.Minute).Unix()}), messageID: 1, file: file, err: ErrLinkExpired},
+		{name: "other message", hash: SignLink(1, file, LinkClaims{}), messageID: 2, file: file, err: ErrInvalidLink},
+		{name: "other file", hash: SignLink(1, file, LinkClaims{}), messageID: 1, file: testFile(8), err: ErrInvalidLink},
+		{name: "previous secret", hash: signedWith("previous", LinkClaims{}), messageID: 1, file: file},
+		{name: "unknown secret", hash: signedWith("unknown", LinkClaims{}), messageID: 1, file: file, err: ErrInvalidLink},
+		{name: "tampered claims", hash: withClaims(SignLink(1, file, LinkClaims{Flags: FlagDownloadOnly}), LinkClaims{}), messageID: 1, file: file, err: ErrInvalidLink},
+		{name: "legacy", hash: legacy, messageID: 1, file: file},
+		{name: "wrong legacy", hash: "abcdef", messageID: 1, file: file, err: ErrInvalidLink},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyLink(tt.hash, tt.messageID, tt.file)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("VerifyLink error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyLinkRevoked(t *testing.T) {
+	withSecrets(t, "current", "", time.Time{})
+	withStores(t)
+	file := testFile(7)
+	hash := SignLink(1, file, LinkClaims{})
+	otherKind := SignLink(1, file, LinkClaims{Kind: 2})
+	legacy := GetShortHash(FileHash(file))
+
+	tests := []struct {
+		name      string
+		revoke    types.Revocation
+		hash      string
+		messageID int
+		err       error
+	}{
+		{name: "message", revoke: types.Revocation{MessageID: 1}, hash: hash, messageID: 1, err: ErrLinkRevoked},
+		{name: "other message", revoke: types.Revocation{MessageID: 2}, hash: hash, messageID: 1},
+		{name: "link", revoke: types.Revocation{Token: hash}, hash: hash, messageID: 1, err: ErrLinkRevoked},
+		{name: "other kind of link", revoke: types.Revocation{Token: otherKind}, hash: hash, messageID: 1},
+		{name: "legacy hash", revoke: types.Revocation{MessageID: 1, Token: legacy}, hash: legacy, messageID: 1, err: ErrLinkRevoked},
+		{name: "legacy hash of another copy", revoke: types.Revocation{MessageID: 2, Token: legacy}, hash: legacy, messageID: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revocations := cache.GetRevocations()
+			if err := revocations.Revoke(&tt.revoke); err != nil {
+				t.Fatal(err)
+			}
+			defer revocations.Unrevoke(tt.revoke.MessageID, tt.revoke.Token)
+			if _, err := VerifyLink(tt.hash, tt.messageID, file); !errors.Is(err, tt.err) {
+				t.Fatalf("VerifyLink error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// withClaims swaps the claims of a signed hash while keeping its MAC.
+func withClaims(hash string, claims LinkClaims) string {
+	_, mac, _ := strings.Cut(hash, ".")
+	return claims.encode() + "." + mac
+}
+
+func TestVerifyLinkSecretRotation(t *testing.T) {
+	withSecrets(t, "current", "previous", time.Now().Add(-time.Minute))
+	file := testFile(7)
+	linkSecret = []byte("previous")
+	hash := SignLink(1, file, LinkClaims{})
+	linkSecret = []byte("current")
+	if _, err := VerifyLink(hash, 1, file); !errors.Is(err, ErrInvalidLink) {
+		t.Fatalf("VerifyLink after the grace period error = %v, want ErrInvalidLink", err)
+	}
+}
+
+func TestVerifyLinkLegacyDisabled(t *testing.T) {
+	withSecrets(t, "current", "", time.Time{})
+	config.ValueOf.AllowLegacyHash = false
+	file := testFile(7)
+	if _, err := VerifyLink(GetShortHash(FileHash(file)), 1, file); !errors.Is(err, ErrInvalidLink) {
+		t.Fatalf("VerifyLink error = %v, want ErrInvalidLink", err)
+	}
+}
+
+func TestSignCallback(t *testing.T) {
+	withSecrets(t, "current", "", time.Time{})
+	signed := SignCallback("limit:42")
+	if data, ok := VerifyCallback(signed); !ok || data != "limit:42" {
+		t.Fatalf("VerifyCallback(%q) = %q, %v", signed, data, ok)
+	}
+	for _, forged := range []string{"limit:43" + signed[len("limit:42"):], "limit:42", "", signed + "x"} {
+		if _, ok := VerifyCallback(forged); ok {
+			t.Fatalf("VerifyCallback(%q) accepted a forged button", forged)
+		}
+	}
+}
+
+func TestVerifyUnlock(t *testing.T) {
+	withSecrets(t, "current", "", time.Time{})
+	valid := SignUnlock(1, time.Now().Add(time.Minute))
+	tests := []struct {
+		name      string
+		value     string
+		messageID int
+		want      bool
+	}{
+		{name: "valid", value: valid, messageID: 1, want: true},
+		{name: "other message", value: valid, messageID: 2},
+		{name: "expired", value: SignUnlock(1, time.Now().Add(-time.Minute)), messageID: 1},
+		{name: "no MAC", value: "abc", messageID: 1},
+	}
+	for _, tt := range tests {
+		if got := VerifyUnlock(tt.value, tt.messageID); got != tt.want {
+			t.Fatalf("%s: VerifyUnlock = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/types"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// randomSlugLength gives 36^7, about 78 billion, random slugs.
+const randomSlugLength = 7
+
+const slugAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+var (
+	ErrInvalidSlug = errors.New("short links need 3 to 32 letters, digits, - or _")
+	slugPattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{2,31}$`)
+)
+
+// NormalizeSlug checks a custom slug. Slugs are case insensitive.
+func NormalizeSlug(slug string) (string, error) {
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	if !slugPattern.MatchString(slug) {
+		return "", ErrInvalidSlug
+	}
+	return slug, nil
+}
+
+func randomSlug() (string, error) {
+	slug := make([]byte, randomSlugLength)
+	max := big.NewInt(int64(len(slugAlphabet)))
+	for i := range slug {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		slug[i] = slugAlphabet[n.Int64()]
+	}
+	return string(slug), nil
+}
+
+// NewSlug stores a short link standing for the link of a message with the
+// given hash. An empty slug gets a random one.
+func NewSlug(messageID int, hash string, slug string, createdBy int64) (*types.Slug, error) {
+	slugs := cache.GetSlugs()
+	if slugs == nil {
+		return nil, errors.New("short links are not available")
+	}
+	record := &types.Slug{
+		MessageID: messageID,
+		Token:     hash,
+		CreatedBy: createdBy,
+	}
+	if slug != "" {
+		name, err := NormalizeSlug(slug)
+		if err != nil {
+			return nil, err
+		}
+		record.Slug = name
+		if err := slugs.Create(record); err != nil {
+			return nil, err
+		}
+		return record, nil
+	}
+	// retry the unlikely collisions of random slugs
+	for i := 0; i < 3; i++ {
+		name, err := randomSlug()
+		if err != nil {
+			return nil, err
+		}
+		record.Slug = name
+		err = slugs.Create(record)
+		if err == nil {
+			return record, nil
+		}
+		if !errors.Is(err, cache.ErrSlugTaken) {
+			return nil, err
+		}
+	}
+	return nil, cache.ErrSlugTaken
+}
+
+// SlugURL returns the address of a short link.
+func SlugURL(slug string) string {
+	return config.ValueOf.Host + "/s/" + slug
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/internal/cache"
+	"errors"
+	"testing"
+)
+
+func TestNormalizeSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		want string
+		err  bool
+	}{
+		{slug: "my-video", want: "my-video"},
+		{slug: " My_Video ", want: "my_video"},
+		{slug: "abc", want: "abc"},
+		{slug: "ab", err: true},
+		{slug: "-video", err: true},
+		{slug: "my video", err: true},
+		{slug: "vidéo", err: true},
+		{slug: "a23456789012345678901234567890123", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.slug, func(t *testing.T) {
+			slug, err := NormalizeSlug(tt.slug)
+			if tt.err {
+				if !errors.Is(err, ErrInvalidSlug) {
+					t.Fatalf("NormalizeSlug = %q, %v, want ErrInvalidSlug", slug, err)
+				}
+				return
+			}
+			if err != nil || slug != tt.want {
+				t.Fatalf("NormalizeSlug = %q, %v, want %q", slug, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSlug(t *testing.T) {
+	tests := []struct {
+		name  string
+		taken string // slug created before
+		slug  string
+		err   error
+	}{
+		{name: "custom", slug: "My-Video"},
+		{name: "random"},
+		{name: "taken", taken: "my-video", slug: "my-video", err: cache.ErrSlugTaken},
+		{name: "taken in other case", taken: "my-video", slug: "MY-VIDEO", err: cache.ErrSlugTaken},
+		{name: "invalid", slug: "a b", err: ErrInvalidSlug},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withStores(t)
+			if tt.taken != "" {
+				if _, err := NewSlug(11, "other", tt.taken, 2); err != nil {
+					t.Fatal(err)
+				}
+			}
+			record, err := NewSlug(10, "abc", tt.slug, 1)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("NewSlug error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.slug == "" && len(record.Slug) != randomSlugLength {
+				t.Fatalf("random slug %q", record.Slug)
+			}
+			messageID, token, err := ParseLinkTarget("https://example.com/s/" + record.Slug)
+			if err != nil || messageID != 10 || token != "abc" {
+				t.Fatalf("the short link parsed as %d, %q, %v", messageID, token, err)
+			}
+		})
+	}
+}
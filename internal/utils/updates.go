@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+// sentMessage finds the message sent with randomID in the result of a send
+// or forward request. Telegram maps the random ID to the message ID with an
+// updateMessageID, and sends the message itself as a new message update,
+// in any order and next to unrelated updates. The message is nil when the
+// result only has its ID.
+func sentMessage(update tg.UpdatesClass, randomID int64) (int, *tg.Message, error) {
+	var updates []tg.UpdateClass
+	switch update := update.(type) {
+	case *tg.Updates:
+		updates = update.Updates
+	case *tg.UpdatesCombined:
+		updates = update.Updates
+	case *tg.UpdateShort:
+		updates = []tg.UpdateClass{update.Update}
+	case *tg.UpdateShortSentMessage:
+		// only sent for requests with a single random ID
+		message := &tg.Message{ID: update.ID, Date: update.Date, Out: update.Out}
+		if update.Media != nil {
+			message.SetMedia(update.Media)
+		}
+		return update.ID, message, nil
+	default:
+		return 0, nil, fmt.Errorf("unexpected result %T", update)
+	}
+
+	messageID := 0
+	for _, update := range updates {
+		if update, ok := update.(*tg.UpdateMessageID); ok && update.RandomID == randomID {
+			messageID = update.ID
+			break
+		}
+	}
+	if messageID == 0 {
+		return 0, nil, fmt.Errorf("no message was sent for random ID %d", randomID)
+	}
+
+	for _, update := range updates {
+		var message tg.MessageClass
+		switch update := update.(type) {
+		case *tg.UpdateNewChannelMessage:
+			message = update.Message
+		case *tg.UpdateNewMessage:
+			message = update.Message
+		default:
+			continue
+		}
+		if message, ok := message.(*tg.Message); ok && message.ID == messageID {
+			return messageID, message, nil
+		}
+	}
+	return messageID, nil, nil
+}
+
+// logChannelMessage returns the log channel message sent with randomID,
+// fetching it when the result of the request doesn't include it.
+func logChannelMessage(ctx *ext.Context, update tg.UpdatesClass, randomID int64) (*tg.Message, error) {
+	messageID, message, err := sentMessage(update, randomID)
+	if err != nil {
+		return nil, err
+	}
+	if message != nil && message.Media != nil {
+		return message, nil
+	}
+	return getLogMessage(ctx, ctx.Raw, ctx.PeerStorage, messageID)
+}
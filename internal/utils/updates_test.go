@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestSentMessage(t *testing.T) {
+	const randomID = 777
+	media := &tg.MessageMediaDocument{}
+	sent := &tg.Message{ID: 10, Media: media}
+	other := &tg.Message{ID: 11}
+
+	tests := []struct {
+		name      string
+		update    tg.UpdatesClass
+		messageID int
+		message   *tg.Message
+		fails     bool
+	}{
+		{
+			name: "channel message",
+			update: &tg.Updates{Updates: []tg.UpdateClass{
+				&tg.UpdateMessageID{ID: 10, RandomID: randomID},
+				&tg.UpdateNewChannelMessage{Message: sent},
+			}},
+			messageID: 10, message: sent,
+		},
+		{
+			name: "message before its ID",
+			update: &tg.Updates{Updates: []tg.UpdateClass{
+				&tg.UpdateNewChannelMessage{Message: sent},
+				&tg.UpdateMessageID{ID: 10, RandomID: randomID},
+			}},
+			messageID: 10, message: sent,
+		},
+		{
+			name: "unrelated updates",
+			update: &tg.Updates{Updates: []tg.UpdateClass{
+				&tg.UpdateMessageID{ID: 11, RandomID: randomID + 1},
+				&tg.UpdateNewChannelMessage{Message: other},
+				&tg.UpdateReadChannelInbox{},
+				&tg.UpdateMessageID{ID: 10, RandomID: randomID},
+				&tg.UpdateNewChannelMessage{Message: sent},
+			}},
+			messageID: 10, message: sent,
+		},
+		{
+			name: "private message",
+			update: &tg.UpdatesCombined{Updates: []tg.UpdateClass{
+				&tg.UpdateMessageID{ID: 10, RandomID: randomID},
+				&tg.UpdateNewMessage{Message: sent},
+			}},
+			messageID: 10, message: sent,
+		},
+		{
+			name: "only the ID",
+			update: &tg.Updates{Updates: []tg.UpdateClass{
+				&tg.UpdateMessageID{ID: 10, RandomID: randomID},
+				&tg.UpdateNewChannelMessage{Message: other},
+			}},
+			messageID: 10,
+		},
+		{
+			name:      "short update",
+			update:    &tg.UpdateShort{Update: &tg.UpdateMessageID{ID: 10, RandomID: randomID}},
+			messageID: 10,
+		},
+		{
+			name:      "short sent message",
+			update:    &tg.UpdateShortSentMessage{ID: 10, Media: media},
+			messageID: 10, message: &tg.Message{ID: 10, Media: media},
+		},
+		{
+			name: "other random ID",
+			update: &tg.Updates{Updates: []tg.UpdateClass{
+				&tg.UpdateMessageID{ID: 11, RandomID: randomID + 1},
+				&tg.UpdateNewChannelMessage{Message: other},
+			}},
+			fails: true,
+		},
+		{name: "no updates", update: &tg.Updates{}, fails: true},
+		{name: "too long", update: &tg.UpdatesTooLong{}, fails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messageID, message, err := sentMessage(tt.update, randomID)
+			if tt.fails {
+				if err == nil {
+					t.Fatalf("sentMessage = %d, %v, want an error", messageID, message)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if messageID != tt.messageID {
+				t.Fatalf("message ID = %d, want %d", messageID, tt.messageID)
+			}
+			if (message == nil) != (tt.message == nil) {
+				t.Fatalf("message = %v, want %v", message, tt.message)
+			}
+			if message != nil && (message.ID != tt.message.ID || message.Media != tt.message.Media) {
+				t.Fatalf("message = %v, want %v", message, tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+// Package zipstream writes uncompressed ZIP64 archives whose total length is
+// known before any content is written, so they can be streamed with a
+// Content-Length header.
+package zipstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+const (
+	localHeaderLen   = 30
+	localExtraLen    = 20 // zip64 extra field with both sizes
+	descriptorLen    = 24 // zip64 data descriptor
+	centralHeaderLen = 46
+	centralExtraLen  = 28 // zip64 extra field with both sizes and the offset
+	zip64EndLen      = 56
+	zip64LocatorLen  = 20
+	endLen           = 22
+
+	zipVersion = 45
+	// bit 3: sizes and CRC follow the data, bit 11: UTF-8 names
+	zipFlags = 0x0808
+
+	uint16max = 0xffff
+	uint32max = 0xffffffff
+)
+
+// Entry describes a file in the archive.
+type Entry struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// Size returns the exact length of an archive holding the given entries.
+func Size(entries []Entry) int64 {
+	var size int64
+	for _, entry := range entries {
+		nameLen := int64(len(entry.Name))
+		size += localHeaderLen + nameLen + localExtraLen + entry.Size + descriptorLen
+		size += centralHeaderLen + nameLen + centralExtraLen
+	}
+	return size + zip64EndLen + zip64LocatorLen + endLen
+}
+
+type written struct {
+	Entry
+	offset int64
+	crc    uint32
+}
+
+// Writer writes an archive entry by entry. Every entry must receive exactly
+// the number of bytes declared in its Size.
+type Writer struct {
+	w       *countWriter
+	entries []written
+	current *entryWriter
+	closed  bool
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: &countWriter{w: w}}
+}
+
+// Create writes the header of a new entry and returns a writer for its
+// content. The previous entry is finished first.
+func (z *Writer) Create(entry Entry) (io.Writer, error) {
+	if z.closed {
+		return nil, errors.New("zipstream: writer is closed")
+	}
+	if err := z.finish(); err != nil {
+		return nil, err
+	}
+	if len(entry.Name) > uint16max {
+		return nil, fmt.Errorf("zipstream: name of %q is too long", entry.Name)
+	}
+	modTime, modDate := dosTime(entry.Modified)
+	buf := make([]byte, localHeaderLen+len(entry.Name)+localExtraLen)
+	b := writeBuf(buf)
+	b.uint32(0x04034b50)
+	b.uint16(zipVersion)
+	b.uint16(zipFlags)
+	b.uint16(0) // store
+	b.uint16(modTime)
+	b.uint16(modDate)
+	b.uint32(0) // crc, in the data descriptor
+	b.uint32(uint32max)
+	b.uint32(uint32max)
+	b.uint16(uint16(len(entry.Name)))
+	b.uint16(localExtraLen)
+	b = b[copy(b, entry.Name):]
+	b.uint16(0x0001)
+	b.uint16(localExtraLen - 4)
+	b.uint64(0)
+	b.uint64(0)
+
+	offset := z.w.count
+	if _, err := z.w.Write(buf); err != nil {
+		return nil, err
+	}
+	z.entries = append(z.entries, written{Entry: entry, offset: offset})
+	z.current = &entryWriter{w: z.w, crc: crc32.NewIEEE(), remaining: entry.Size}
+	return z.current, nil
+}
+
+// finish writes the data descriptor of the current entry.
+func (z *Writer) finish() error {
+	if z.current == nil {
+		return nil
+	}
+	current := z.current
+	z.current = nil
+	if current.remaining != 0 {
+		return fmt.Errorf("zipstream: entry is %d bytes short", current.remaining)
+	}
+	entry := &z.entries[len(z.entries)-1]
+	entry.crc = current.crc.Sum32()
+	buf := make([]byte, descriptorLen)
+	b := writeBuf(buf)
+	b.uint32(0x08074b50)
+	b.uint32(entry.crc)
+	b.uint64(uint64(entry.Size))
+	b.uint64(uint64(entry.Size))
+	_, err := z.w.Write(buf)
+	return err
+}
+
+// Close finishes the last entry and writes the central directory.
+func (z *Writer) Close() error {
+	if z.closed {
+		return nil
+	}
+	if err := z.finish(); err != nil {
+		return err
+	}
+	z.closed = true
+
+	start := z.w.count
+	for _, entry := range z.entries {
+		modTime, modDate := dosTime(entry.Modified)
+		buf := make([]byte, centralHeaderLen+len(entry.Name)+centralExtraLen)
+		b := writeBuf(buf)
+		b.uint32(0x02014b50)
+		b.uint16(zipVersion)
+		b.uint16(zipVersion)
+		b.uint16(zipFlags)
+		b.uint16(0) // store
+		b.uint16(modTime)
+		b.uint16(modDate)
+		b.uint32(entry.crc)
+		b.uint32(uint32max)
+		b.uint32(uint32max)
+		b.uint16(uint16(len(entry.Name)))
+		b.uint16(centralExtraLen)
+		b.uint16(0) // comment
+		b.uint16(0) // disk number
+		b.uint16(0) // internal attributes
+		b.uint32(0) // external attributes
+		b.uint32(uint32max)
+		b = b[copy(b, entry.Name):]
+		b.uint16(0x0001)
+		b.uint16(centralExtraLen - 4)
+		b.uint64(uint64(entry.Size))
+		b.uint64(uint64(entry.Size))
+		b.uint64(uint64(entry.offset))
+		if _, err := z.w.Write(buf); err != nil {
+			return err
+		}
+	}
+	end := z.w.count
+	count := uint64(len(z.entries))
+
+	buf := make([]byte, zip64EndLen+zip64LocatorLen+endLen)
+	b := writeBuf(buf)
+	// zip64 end of central directory record
+	b.uint32(0x06064b50)
+	b.uint64(zip64EndLen - 12)
+	b.uint16(zipVersion)
+	b.uint16(zipVersion)
+	b.uint32(0)
+	b.uint32(0)
+	b.uint64(count)
+	b.uint64(count)
+	b.uint64(uint64(end - start))
+	b.uint64(uint64(start))
+	// zip64 end of central directory locator
+	b.uint32(0x07064b50)
+	b.uint32(0)
+	b.uint64(uint64(end))
+	b.uint32(1)
+	// end of central directory record
+	b.uint32(0x06054b50)
+	b.uint16(0)
+	b.uint16(0)
+	b.uint16(uint16max)
+	b.uint16(uint16max)
+	b.uint32(uint32max)
+	b.uint32(uint32max)
+	b.uint16(0)
+	_, err := z.w.Write(buf)
+	return err
+}
+
+type entryWriter struct {
+	w         io.Writer
+	crc       hash.Hash32
+	remaining int64
+}
+
+func (e *entryWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > e.remaining {
+		return 0, errors.New("zipstream: entry is longer than its declared size")
+	}
+	n, err := e.w.Write(p)
+	e.crc.Write(p[:n])
+	e.remaining -= int64(n)
+	return n, err
+}
+
+type countWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// writeBuf fills a preallocated header, advancing as values are written.
+type writeBuf []byte
+
+func (b *writeBuf) uint16(v uint16) {
+	binary.LittleEndian.PutUint16(*b, v)
+	*b = (*b)[2:]
+}
+
+func (b *writeBuf) uint32(v uint32) {
+	binary.LittleEndian.PutUint32(*b, v)
+	*b = (*b)[4:]
+}
+
+func (b *writeBuf) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(*b, v)
+	*b = (*b)[8:]
+}
+
+func dosTime(t time.Time) (uint16, uint16) {
+	if t.IsZero() || t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	t = t.UTC()
+	return uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()>>1),
+		uint16((t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day())
+}
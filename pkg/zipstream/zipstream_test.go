@@ -0,0 +1,177 @@
+package zipstream
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func content(entry Entry) []byte {
+	return bytes.Repeat([]byte(entry.Name[:1]), int(entry.Size))
+}
+
+func TestWriter(t *testing.T) {
+	modified := time.Date(2024, 5, 17, 13, 45, 30, 0, time.UTC)
+	tests := []struct {
+		name    string
+		entries []Entry
+	}{
+		{name: "empty archive"},
+		{name: "one file", entries: []Entry{{Name: "video.mp4", Size: 1000, Modified: modified}}},
+		{name: "empty file", entries: []Entry{{Name: "empty.txt", Modified: modified}}},
+		{name: "several files", entries: []Entry{
+			{Name: "a.mp4", Size: 4096, Modified: modified},
+			{Name: "b.txt", Size: 1, Modified: modified},
+			{Name: "c.jpg", Size: 70000, Modified: modified},
+		}},
+		{name: "unicode name", entries: []Entry{{Name: "película ñ.mkv", Size: 10, Modified: modified}}},
+		{name: "before 1980", entries: []Entry{{Name: "old.txt", Size: 3, Modified: time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			z := NewWriter(&buf)
+			for _, entry := range tt.entries {
+				w, err := z.Create(entry)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write(content(entry)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := z.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if size := Size(tt.entries); int64(buf.Len()) != size {
+				t.Fatalf("Size = %d, archive is %d bytes", size, buf.Len())
+			}
+
+			r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(r.File) != len(tt.entries) {
+				t.Fatalf("archive has %d files, want %d", len(r.File), len(tt.entries))
+			}
+			for i, file := range r.File {
+				entry := tt.entries[i]
+				if file.Name != entry.Name || file.UncompressedSize64 != uint64(entry.Size) {
+					t.Fatalf("file %d is %q of %d bytes, want %q of %d", i, file.Name, file.UncompressedSize64, entry.Name, entry.Size)
+				}
+				if entry.Modified.Year() >= 1980 && !file.Modified.Equal(entry.Modified) {
+					t.Fatalf("file %d was modified %v, want %v", i, file.Modified, entry.Modified)
+				}
+				rc, err := file.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				// reading to the end checks the CRC
+				data, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					t.Fatalf("file %d: %v", i, err)
+				}
+				if !bytes.Equal(data, content(entry)) {
+					t.Fatalf("file %d has other content", i)
+				}
+			}
+		})
+	}
+}
+
+func TestWriterSizeMismatch(t *testing.T) {
+	z := NewWriter(io.Discard)
+	w, err := z.Create(Entry{Name: "a", Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("abc")); err == nil {
+		t.Fatal("Write accepted more bytes than declared")
+	}
+	if _, err := w.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := z.Create(Entry{Name: "b"}); err == nil {
+		t.Fatal("Create accepted a short entry")
+	}
+
+	z = NewWriter(io.Discard)
+	if _, err := z.Create(Entry{Name: strings.Repeat("a", uint16max+1)}); err == nil {
+		t.Fatal("Create accepted a name that doesn't fit the header")
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := z.Create(Entry{Name: "a"}); err == nil {
+		t.Fatal("Create accepted an entry after Close")
+	}
+}
+
+// zeros reads as an endless run of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// TestWriterZip64 writes an entry past 4 GiB, so its size and the offset of
+// the next entry only fit the ZIP64 fields.
+func TestWriterZip64(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes more than 4 GiB")
+	}
+	entries := []Entry{
+		{Name: "big.bin", Size: uint32max + 100},
+		{Name: "small.txt", Size: 5},
+	}
+	// only the headers are kept, the content of the big file is counted
+	var headers bytes.Buffer
+	counter := &countWriter{w: &headers}
+	z := NewWriter(counter)
+	w, err := z.Create(entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerLen := headers.Len()
+	discard := &countWriter{w: io.Discard}
+	counter.w = discard
+	if _, err := io.CopyN(w, zeros{}, entries[0].Size); err != nil {
+		t.Fatal(err)
+	}
+	counter.w = &headers
+	w, err = z.Create(entries[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if size := Size(entries); counter.count != size {
+		t.Fatalf("Size = %d, archive is %d bytes", size, counter.count)
+	}
+	if discard.count != entries[0].Size || headerLen != localHeaderLen+len(entries[0].Name)+localExtraLen {
+		t.Fatalf("wrote %d bytes of content after a %d byte header", discard.count, headerLen)
+	}
+
+	// the central directory records the offset of the second entry, past
+	// 4 GiB, in its zip64 extra field
+	archive := headers.Bytes()
+	central := bytes.LastIndex(archive, []byte("PK\x01\x02"))
+	if central < 0 {
+		t.Fatal("no central directory header for the second entry")
+	}
+	extra := archive[central+centralHeaderLen+len(entries[1].Name):]
+	want := z.entries[1].offset
+	if got := int64(binary.LittleEndian.Uint64(extra[20:28])); got != want || want <= uint32max {
+		t.Fatalf("offset of the second entry = %d, want %d past 4 GiB", got, want)
+	}
+}